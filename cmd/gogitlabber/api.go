@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/scornet256/go-logger"
+)
+
+// apiClient holds the http plumbing shared by all backends
+type apiClient struct {
+	httpClient *http.Client
+	baseURL    string
+	token      string
+	authorize  func(req *http.Request, token string)
+	retry      RetryPolicy
+	retries    *atomic.Int64
+	limiter    *rateLimiter
+}
+
+// api client
+func newAPIClient(baseURL, token string, authorize func(req *http.Request, token string)) apiClient {
+	return apiClient{
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		baseURL:   baseURL,
+		token:     token,
+		authorize: authorize,
+		retry:     RetryPolicy{Attempts: 1},
+		retries:   &atomic.Int64{},
+		limiter:   newRateLimiter(0),
+	}
+}
+
+// apply retry and rate limit settings
+func (c *apiClient) configure(conf *Config) {
+	c.retry = newRetryPolicy(conf)
+	c.limiter = newRateLimiter(conf.MaxRequestsPerSecond)
+}
+
+// retryCounter is implemented by providers built on apiClient
+type retryCounter interface {
+	RetryCount() int
+}
+
+// number of retried api requests
+func (c *apiClient) RetryCount() int {
+	return int(c.retries.Load())
+}
+
+// make authenticated get request, retrying transient failures
+func (c *apiClient) get(ctx context.Context, apiURL string) (*http.Response, error) {
+	var resp *http.Response
+	retries, err := c.retry.do(ctx, "API request", func() error {
+		var err error
+		for pauses := 0; ; pauses++ {
+			resp, err = c.getOnce(ctx, apiURL)
+			if err != nil {
+				return err
+			}
+
+			// waiting out a rate limit is not a failed attempt
+			if pauses >= maxRateLimitPauses || !c.limiter.observe(resp) {
+				break
+			}
+			closeBody(resp)
+		}
+
+		if retryableStatus(resp.StatusCode) {
+			closeBody(resp)
+			return &httpStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+		}
+		return nil
+	})
+	c.retries.Add(int64(retries))
+
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// make a single authenticated get request
+func (c *apiClient) getOnce(ctx context.Context, apiURL string) (*http.Response, error) {
+	if err := c.limiter.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	c.authorize(req, c.token)
+	req.Header.Set("Accept", "application/json")
+
+	logger.Print("Making API request to: "+redactToken(apiURL, c.token), nil)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("making request: %w", err)
+	}
+
+	return resp, nil
+}
+
+// make authenticated get request and decode json response
+func (c *apiClient) getJSON(ctx context.Context, apiURL string, target any) (http.Header, error) {
+	resp, err := c.get(ctx, apiURL)
+	if err != nil {
+		return nil, err
+	}
+	defer closeBody(resp)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &httpStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(target); err != nil {
+		return nil, fmt.Errorf("decoding JSON response: %w", err)
+	}
+
+	return resp.Header, nil
+}
+
+// connection validation
+func (c *apiClient) validate(ctx context.Context, apiURL string) error {
+	resp, err := c.get(ctx, apiURL)
+	if err != nil {
+		return fmt.Errorf("making validation request: %w", err)
+	}
+	defer closeBody(resp)
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("invalid or expired token")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("API validation failed with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// parse link header into urls keyed by rel
+func parseLinkHeader(header string) map[string]string {
+	links := map[string]string{}
+
+	for _, part := range strings.Split(header, ",") {
+		sections := strings.Split(part, ";")
+		if len(sections) < 2 {
+			continue
+		}
+
+		link := strings.Trim(strings.TrimSpace(sections[0]), "<>")
+		for _, param := range sections[1:] {
+			param = strings.TrimSpace(param)
+			if rel, ok := strings.CutPrefix(param, "rel="); ok {
+				links[strings.Trim(rel, `"`)] = link
+			}
+		}
+	}
+
+	return links
+}
+
+// hide token in text that gets logged
+func redactToken(text, token string) string {
+	if token == "" {
+		return text
+	}
+	return strings.ReplaceAll(text, token, "[REDACTED]")
+}
+
+// close response body
+func closeBody(resp *http.Response) {
+	if err := resp.Body.Close(); err != nil {
+		logger.Print("WARNING: failed to close response body: "+err.Error(), nil)
+	}
+}
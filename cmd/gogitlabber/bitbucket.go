@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/scornet256/go-logger"
+)
+
+// BitbucketClient encapsulates the Bitbucket Server API client functionality
+type BitbucketClient struct {
+	apiClient
+	includeArchived string
+	username        string
+}
+
+// bitbucket repo information
+type BitbucketRepository struct {
+	ID       int    `json:"id"`
+	Slug     string `json:"slug"`
+	Name     string `json:"name"`
+	Archived bool   `json:"archived"`
+	Project  struct {
+		Key string `json:"key"`
+	} `json:"project"`
+	Links struct {
+		Clone []struct {
+			Href string `json:"href"`
+			Name string `json:"name"`
+		} `json:"clone"`
+	} `json:"links"`
+}
+
+// bitbucket paged response
+type BitbucketPage struct {
+	Size          int                   `json:"size"`
+	Limit         int                   `json:"limit"`
+	Start         int                   `json:"start"`
+	IsLastPage    bool                  `json:"isLastPage"`
+	NextPageStart int                   `json:"nextPageStart"`
+	Values        []BitbucketRepository `json:"values"`
+}
+
+// bitbucket api options
+type BitbucketAPIOptions struct {
+	IncludeArchived string
+	Limit           int
+	Start           int
+}
+
+// register backend
+func init() {
+	registerProvider("bitbucket", newBitbucketProvider)
+}
+
+// bitbucket api client
+func NewBitbucketClient(baseURL, token string) *BitbucketClient {
+	return &BitbucketClient{
+		apiClient: newAPIClient(baseURL, token, func(req *http.Request, token string) {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}),
+	}
+}
+
+// bitbucket provider from config
+func newBitbucketProvider(conf *Config) Provider {
+	client := NewBitbucketClient(conf.GitHost, conf.GitToken)
+	client.includeArchived = conf.IncludeArchived
+	client.configure(conf)
+	return client
+}
+
+// fetch bitbucket repos
+func (c *BitbucketClient) FetchRepositories(ctx context.Context) ([]Repository, error) {
+	options := BitbucketAPIOptions{
+		IncludeArchived: c.includeArchived,
+		Limit:           100,
+		Start:           0,
+	}
+
+	return c.fetchAllRepositories(ctx, options)
+}
+
+// craft git url without credentials
+func (c *BitbucketClient) CloneURL(repo Repository) string {
+	return httpsCloneURL(c.baseURL, "scm/"+repo.PathWithNamespace)
+}
+
+// bitbucket wants the token owner as username
+func (c *BitbucketClient) Credentials() (string, string) {
+	if c.username == "" {
+		return "x-token-auth", c.token
+	}
+	return c.username, c.token
+}
+
+// connection validation
+func (c *BitbucketClient) ValidateConnection(ctx context.Context) error {
+	apiURL := fmt.Sprintf("https://%s/rest/api/1.0/repos?limit=1", c.baseURL)
+
+	resp, err := c.get(ctx, apiURL)
+	if err != nil {
+		return fmt.Errorf("making validation request: %w", err)
+	}
+	defer closeBody(resp)
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("invalid or expired token")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("API validation failed with status %d", resp.StatusCode)
+	}
+
+	// remember who the token belongs to for git operations
+	c.username = resp.Header.Get("X-AUSERNAME")
+
+	return nil
+}
+
+// fetch all repos with pagination
+func (c *BitbucketClient) fetchAllRepositories(ctx context.Context, options BitbucketAPIOptions) ([]Repository, error) {
+	var allRepositories []Repository
+
+	for {
+		page, err := c.fetchRepositoryPage(ctx, options)
+		if err != nil {
+			return nil, fmt.Errorf("fetching page at %d: %w", options.Start, err)
+		}
+
+		// convert bitbucket repositories to repo type
+		repositories := convertBitbucketRepositories(page.Values, options.IncludeArchived)
+		allRepositories = append(allRepositories, repositories...)
+
+		logger.Print(fmt.Sprintf("Fetched page at %d (%d repositories)", page.Start, page.Size), nil)
+
+		// check if we have more pages
+		if page.IsLastPage || page.NextPageStart <= options.Start {
+			break
+		}
+
+		options.Start = page.NextPageStart
+	}
+
+	return allRepositories, nil
+}
+
+// fetch single page of repo
+func (c *BitbucketClient) fetchRepositoryPage(ctx context.Context, options BitbucketAPIOptions) (BitbucketPage, error) {
+	apiURL, err := c.buildAPIURL(options)
+	if err != nil {
+		return BitbucketPage{}, fmt.Errorf("building API URL: %w", err)
+	}
+
+	var page BitbucketPage
+	if _, err := c.getJSON(ctx, apiURL, &page); err != nil {
+		return BitbucketPage{}, err
+	}
+
+	return page, nil
+}
+
+// build api url
+func (c *BitbucketClient) buildAPIURL(options BitbucketAPIOptions) (string, error) {
+	baseURL := fmt.Sprintf("https://%s/rest/api/1.0/repos", c.baseURL)
+
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("parsing base URL: %w", err)
+	}
+
+	query := u.Query()
+	query.Set("limit", strconv.Itoa(options.Limit))
+	query.Set("start", strconv.Itoa(options.Start))
+
+	// handle archived (ignored by servers older than 8.0)
+	switch options.IncludeArchived {
+	case "excluded":
+		query.Set("archived", "ACTIVE")
+	case "exclusive":
+		query.Set("archived", "ARCHIVED")
+	case "any":
+		query.Set("archived", "ALL")
+	}
+
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// convert bitbucket repos to repo type
+func convertBitbucketRepositories(bitbucketRepos []BitbucketRepository, includeArchived string) []Repository {
+	var repositories []Repository
+
+	for _, bitbucketRepo := range bitbucketRepos {
+		if includeArchived == "excluded" && bitbucketRepo.Archived {
+			continue
+		}
+		if includeArchived == "exclusive" && !bitbucketRepo.Archived {
+			continue
+		}
+
+		repository := Repository{
+			ID:                bitbucketRepo.ID,
+			Name:              bitbucketRepo.Name,
+			PathWithNamespace: bitbucketRepo.Project.Key + "/" + bitbucketRepo.Slug,
+		}
+		for _, link := range bitbucketRepo.Links.Clone {
+			if link.Name == "ssh" {
+				repository.SSHURL = link.Href
+			}
+		}
+
+		repositories = append(repositories, repository)
+	}
+
+	return repositories
+}
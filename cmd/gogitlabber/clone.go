@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing/protocol/packp"
+	"github.com/scornet256/go-logger"
+)
+
+// CloneSettings controls how much history and which objects are cloned
+type CloneSettings struct {
+	Depth        int
+	SingleBranch bool
+	Filter       string
+}
+
+// CloneOverride changes clone settings for repositories matching a pattern
+type CloneOverride struct {
+	Match        string  `yaml:"match"`
+	CloneDepth   *int    `yaml:"clone_depth"`
+	SingleBranch *bool   `yaml:"single_branch"`
+	CloneFilter  *string `yaml:"clone_filter"`
+}
+
+// compiled clone override
+type cloneRule struct {
+	pattern  *regexp.Regexp
+	override CloneOverride
+}
+
+// compile clone overrides, patterns work like include and exclude
+func newCloneRules(overrides []CloneOverride) ([]cloneRule, error) {
+	var rules []cloneRule
+	for _, override := range overrides {
+		re, err := compilePattern(override.Match)
+		if err != nil {
+			return nil, fmt.Errorf("invalid clone_overrides pattern %q: %w", override.Match, err)
+		}
+		rules = append(rules, cloneRule{pattern: re, override: override})
+	}
+	return rules, nil
+}
+
+// clone settings for a repository, the first matching override wins
+func resolveCloneSettings(defaults CloneSettings, rules []cloneRule, repoName string) CloneSettings {
+	settings := defaults
+	for _, rule := range rules {
+		if !rule.pattern.MatchString(repoName) {
+			continue
+		}
+		if rule.override.CloneDepth != nil {
+			settings.Depth = *rule.override.CloneDepth
+		}
+		if rule.override.SingleBranch != nil {
+			settings.SingleBranch = *rule.override.SingleBranch
+		}
+		if rule.override.CloneFilter != nil {
+			settings.Filter = *rule.override.CloneFilter
+		}
+		break
+	}
+	return settings
+}
+
+// check clone settings for invalid values
+func (settings CloneSettings) validate() error {
+	if settings.Depth < 0 {
+		return fmt.Errorf("clone_depth must not be negative")
+	}
+	if _, err := settings.packFilter(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// translate filter setting to the protocol filter
+func (settings CloneSettings) packFilter() (packp.Filter, error) {
+	switch settings.Filter {
+	case "":
+		return "", nil
+	case "blob:none":
+		return packp.FilterBlobNone(), nil
+	case "tree:0":
+		return packp.FilterTreeDepth(0), nil
+	default:
+		return "", fmt.Errorf("invalid clone_filter option: %s (must be blob:none|tree:0)", settings.Filter)
+	}
+}
+
+// mark a partial clone as such and check it out with the git command,
+// go-git can't fetch the objects left out by the filter on demand
+func checkoutPartialClone(ctx context.Context, remote *gitRemote, repo *git.Repository, repoDestination, filter string) error {
+	cfg, err := repo.Config()
+	if err != nil {
+		return fmt.Errorf("reading config: %w", err)
+	}
+	// extensions.partialclone would lock out go-git, marking the remote is enough for git
+	cfg.Raw.Section("remote").Subsection(git.DefaultRemoteName).
+		SetOption("promisor", "true").
+		SetOption("partialclonefilter", filter)
+	if err := repo.SetConfig(cfg); err != nil {
+		return fmt.Errorf("writing config: %w", err)
+	}
+	if err := markPromisorPacks(repoDestination); err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, "git", "-C", repoDestination, "reset", "--quiet", "--hard", "HEAD")
+	cmd.Env = append(os.Environ(), remote.gitCommandEnv()...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("checking out partial clone: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+// check if a repository is a partial clone
+func isPartialClone(repo *git.Repository) bool {
+	cfg, err := repo.Config()
+	if err != nil {
+		return false
+	}
+	return cfg.Raw.Section("remote").Subsection(git.DefaultRemoteName).Option("promisor") == "true"
+}
+
+// mark packs fetched into partial clones, they can refer to objects left out by the filter
+func markFetchedPacks(repo *git.Repository, repoDestination string) {
+	if !isPartialClone(repo) {
+		return
+	}
+	if err := markPromisorPacks(repoDestination); err != nil {
+		logger.Print("WARNING: failed to mark promisor packs: "+err.Error(), nil)
+	}
+}
+
+// mark packs fetched by go-git as promisor packs, otherwise git treats
+// the objects left out by the filter as corrupt and fsck and gc fail
+func markPromisorPacks(repoDestination string) error {
+	packs, err := filepath.Glob(filepath.Join(repoDestination, ".git", "objects", "pack", "pack-*.pack"))
+	if err != nil {
+		return fmt.Errorf("listing packs: %w", err)
+	}
+
+	for _, pack := range packs {
+		marker := strings.TrimSuffix(pack, ".pack") + ".promisor"
+		if _, err := os.Stat(marker); err == nil {
+			continue
+		}
+		if err := os.WriteFile(marker, nil, 0644); err != nil {
+			return fmt.Errorf("marking promisor pack: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// environment that lets the git command authenticate like we do,
+// credentials are passed as config through the environment to keep them off the command line
+func (r *gitRemote) gitCommandEnv() []string {
+	env := []string{"GIT_TERMINAL_PROMPT=0"}
+
+	if r.protocol == "ssh" {
+		sshCommand := "ssh"
+		if r.sshKeyFile != "" {
+			sshCommand += " -i '" + r.sshKeyFile + "' -o IdentitiesOnly=yes"
+		}
+		if r.sshKnownHosts != "" {
+			sshCommand += " -o UserKnownHostsFile='" + r.sshKnownHosts + "'"
+		}
+		return append(env, "GIT_SSH_COMMAND="+sshCommand)
+	}
+
+	username, password := r.provider.Credentials()
+	credentials := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	return append(env,
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=http.extraHeader",
+		"GIT_CONFIG_VALUE_0=Authorization: Basic "+credentials,
+	)
+}
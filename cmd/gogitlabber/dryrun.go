@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/go-git/go-git/v6"
+)
+
+// planned action for a repository
+type PlannedOperation struct {
+	RepoName  string
+	Operation string
+	Reason    string
+}
+
+// determine what would happen to each repository without touching disk
+func planRepositories(repositories []Repository, hostState, incremental *HostState) []PlannedOperation {
+	plan := make([]PlannedOperation, 0, len(repositories))
+	for _, repo := range repositories {
+		if oldPath := renamedFrom(repo, hostState); oldPath != "" {
+			plan = append(plan, PlannedOperation{RepoName: repo.PathWithNamespace, Operation: "move", Reason: "from " + oldPath})
+			continue
+		}
+		if isUnchanged(repo, incremental) {
+			plan = append(plan, PlannedOperation{RepoName: repo.PathWithNamespace, Operation: "unchanged"})
+			continue
+		}
+		plan = append(plan, planRepository(repo))
+	}
+	return plan
+}
+
+// determine what would happen to a single repository
+func planRepository(repo Repository) PlannedOperation {
+	repoName := repo.PathWithNamespace
+	repoDestination := localPath(repoName)
+
+	gitRepo, err := git.PlainOpen(repoDestination)
+	if err != nil {
+		if err != git.ErrRepositoryNotExists {
+			return PlannedOperation{RepoName: repoName, Operation: "conflict", Reason: err.Error()}
+		}
+
+		// cloning into a missing or empty directory is fine
+		entries, readErr := os.ReadDir(repoDestination)
+		if readErr == nil && len(entries) > 0 {
+			return PlannedOperation{RepoName: repoName, Operation: "conflict", Reason: "not a git repository"}
+		}
+		if readErr != nil && !os.IsNotExist(readErr) {
+			return PlannedOperation{RepoName: repoName, Operation: "conflict", Reason: readErr.Error()}
+		}
+
+		return PlannedOperation{RepoName: repoName, Operation: "clone"}
+	}
+
+	// mirrors have no worktree that could hold local changes
+	if globalConfig.Mode == "mirror" {
+		return PlannedOperation{RepoName: repoName, Operation: "pull"}
+	}
+
+	// fetching leaves the worktree alone, local changes don't matter
+	if globalConfig.UpdateStrategy == "fetch" {
+		return PlannedOperation{RepoName: repoName, Operation: "fetch"}
+	}
+
+	worktree, err := gitRepo.Worktree()
+	if err != nil {
+		return PlannedOperation{RepoName: repoName, Operation: "conflict", Reason: err.Error()}
+	}
+
+	status, err := worktree.Status()
+	if err != nil {
+		return PlannedOperation{RepoName: repoName, Operation: "conflict", Reason: err.Error()}
+	}
+
+	if errorType := localChanges(status); errorType != "" {
+		return PlannedOperation{RepoName: repoName, Operation: "skip", Reason: errorType + " changes"}
+	}
+
+	return PlannedOperation{RepoName: repoName, Operation: "pull"}
+}
+
+// print planned operations
+func printPlan(name string, plan []PlannedOperation) {
+	counts := map[string]int{}
+
+	fmt.Println("")
+	fmt.Printf("Plan for %s:\n", name)
+	for _, planned := range plan {
+		counts[planned.Operation]++
+		if planned.Reason != "" {
+			fmt.Printf(" %-9s %s (%s)\n", planned.Operation, planned.RepoName, planned.Reason)
+		} else {
+			fmt.Printf(" %-9s %s\n", planned.Operation, planned.RepoName)
+		}
+	}
+
+	fmt.Println("")
+	fmt.Printf(
+		"Summary:\n"+
+			" Would clone: %v\n"+
+			" Would pull: %v\n"+
+			" Would fetch: %v\n"+
+			" Would move: %v\n"+
+			" Would skip: %v\n"+
+			" Unchanged: %v\n"+
+			" Conflicts: %v\n\n",
+		counts["clone"],
+		counts["pull"],
+		counts["fetch"],
+		counts["move"],
+		counts["skip"],
+		counts["unchanged"],
+		counts["conflict"],
+	)
+}
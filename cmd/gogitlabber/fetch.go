@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	"github.com/scornet256/go-logger"
+)
+
+// fetch repo without touching the worktree, local changes don't matter
+func fetchRepository(ctx context.Context, remote *gitRemote, repoName, repoDestination, gitURL string) GitOperationResult {
+	logger.Print("Fetching repository: "+repoName, nil)
+
+	repo, depth, err := openCheckout(remote, repoName, repoDestination, gitURL)
+	if err != nil {
+		return GitOperationResult{
+			RepoName:  repoName,
+			Operation: "error",
+			Error:     err,
+		}
+	}
+
+	err = repo.FetchContext(ctx, &git.FetchOptions{
+		RemoteName:    git.DefaultRemoteName,
+		ClientOptions: remote.clientOptions,
+		Depth:         depth,
+		Progress:      nil,
+	})
+	if err != nil {
+		if err == git.NoErrAlreadyUpToDate {
+			logger.Print("Repository already up to date: "+repoName, nil)
+		} else {
+			return GitOperationResult{
+				RepoName:  repoName,
+				Operation: "error",
+				Error:     fmt.Errorf("fetching repository: %w", err),
+				ErrorType: "other",
+			}
+		}
+	}
+
+	markFetchedPacks(repo, repoDestination)
+
+	behind, ahead, err := compareWithUpstream(repo)
+	if err != nil {
+		logger.Print("WARNING: failed to compare "+repoName+" with its upstream: "+err.Error(), nil)
+	}
+
+	return GitOperationResult{
+		RepoName:  repoName,
+		Operation: "fetched",
+		Behind:    behind,
+		Ahead:     ahead,
+	}
+}
+
+// count commits the current branch is behind and ahead of its upstream,
+// detached heads and branches without upstream count as in sync
+func compareWithUpstream(repo *git.Repository) (behind, ahead int, err error) {
+	head, err := repo.Head()
+	if err != nil {
+		return 0, 0, fmt.Errorf("reading head: %w", err)
+	}
+	if !head.Name().IsBranch() {
+		return 0, 0, nil
+	}
+
+	cfg, err := repo.Config()
+	if err != nil {
+		return 0, 0, fmt.Errorf("getting config: %w", err)
+	}
+
+	// the upstream is configured for cloned branches, fall back to the same name on origin
+	remoteName, mergeRef := git.DefaultRemoteName, head.Name()
+	if branch, ok := cfg.Branches[head.Name().Short()]; ok {
+		if branch.Remote != "" {
+			remoteName = branch.Remote
+		}
+		if branch.Merge != "" {
+			mergeRef = branch.Merge
+		}
+	}
+
+	upstream, err := repo.Reference(plumbing.NewRemoteReferenceName(remoteName, mergeRef.Short()), true)
+	if err == plumbing.ErrReferenceNotFound {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("reading upstream: %w", err)
+	}
+
+	localCommit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return 0, 0, fmt.Errorf("reading commit %s: %w", head.Hash(), err)
+	}
+	upstreamCommit, err := repo.CommitObject(upstream.Hash())
+	if err != nil {
+		return 0, 0, fmt.Errorf("reading commit %s: %w", upstream.Hash(), err)
+	}
+
+	return countDivergence(repo, localCommit, upstreamCommit)
+}
+
+// sides of the history a commit is reachable from
+const (
+	reachableFromLocal = 1 << iota
+	reachableFromUpstream
+	reachableFromBoth = reachableFromLocal | reachableFromUpstream
+)
+
+// shared commits walked after the history looks settled, like git does to
+// get past commits made with a clock running behind
+const divergenceSlop = 5
+
+// count commits only reachable from one of the tips, walking newest first from both
+// and stopping once only shared history is left, so only the history above the merge base is read
+func countDivergence(repo *git.Repository, local, upstream *object.Commit) (behind, ahead int, err error) {
+	marks := map[plumbing.Hash]int{local.Hash: reachableFromLocal}
+	marks[upstream.Hash] |= reachableFromUpstream
+
+	// shared commits newer than any commit seen on one side only can still reach it
+	oldest := local.Committer.When
+	if upstream.Committer.When.Before(oldest) {
+		oldest = upstream.Committer.When
+	}
+
+	var queue []*object.Commit
+	queued := map[plumbing.Hash]bool{}
+	push := func(commit *object.Commit) {
+		if queued[commit.Hash] {
+			return
+		}
+		queued[commit.Hash] = true
+		i := sort.Search(len(queue), func(i int) bool {
+			return queue[i].Committer.When.Before(commit.Committer.When)
+		})
+		queue = append(queue, nil)
+		copy(queue[i+1:], queue[i:])
+		queue[i] = commit
+	}
+	push(local)
+	push(upstream)
+
+	for slop := divergenceSlop; len(queue) > 0; {
+		if pendingQueued(queue, marks, oldest) {
+			slop = divergenceSlop
+		} else if slop == 0 {
+			break
+		} else {
+			slop--
+		}
+
+		commit := queue[0]
+		queue = queue[1:]
+		delete(queued, commit.Hash)
+		mark := marks[commit.Hash]
+
+		for _, parentHash := range commit.ParentHashes {
+			if marks[parentHash]|mark == marks[parentHash] {
+				continue
+			}
+			parent, err := repo.CommitObject(parentHash)
+			if err == plumbing.ErrObjectNotFound {
+				// history of shallow clones ends early
+				continue
+			}
+			if err != nil {
+				return 0, 0, fmt.Errorf("reading commit %s: %w", parentHash, err)
+			}
+			marks[parentHash] |= mark
+			if marks[parentHash] != reachableFromBoth && parent.Committer.When.Before(oldest) {
+				oldest = parent.Committer.When
+			}
+			push(parent)
+		}
+	}
+
+	for _, mark := range marks {
+		switch mark {
+		case reachableFromLocal:
+			ahead++
+		case reachableFromUpstream:
+			behind++
+		}
+	}
+
+	return behind, ahead, nil
+}
+
+// check if the walk still has to go on, either because a queued commit is not shared by
+// both sides yet, because it became shared after its parents were marked for one side only
+// or because it is not older than the oldest commit seen on one side only
+func pendingQueued(queue []*object.Commit, marks map[plumbing.Hash]int, oldest time.Time) bool {
+	for _, commit := range queue {
+		if marks[commit.Hash] != reachableFromBoth || !commit.Committer.When.Before(oldest) {
+			return true
+		}
+		for _, parentHash := range commit.ParentHashes {
+			if mark, ok := marks[parentHash]; ok && mark != reachableFromBoth {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+)
+
+// repository with commits built by hand, so commit times and parents are exact
+type divergenceRepo struct {
+	t    *testing.T
+	repo *git.Repository
+	tree plumbing.Hash
+}
+
+func newDivergenceRepo(t *testing.T) *divergenceRepo {
+	repo, err := git.PlainInit(t.TempDir(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree := repo.Storer.NewEncodedObject()
+	if err := (&object.Tree{}).Encode(tree); err != nil {
+		t.Fatal(err)
+	}
+	treeHash, err := repo.Storer.SetEncodedObject(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &divergenceRepo{t: t, repo: repo, tree: treeHash}
+}
+
+// store a commit made the given number of minutes after the epoch of the test
+func (r *divergenceRepo) commit(message string, minute int, parents ...plumbing.Hash) plumbing.Hash {
+	signature := object.Signature{
+		Name:  "test",
+		Email: "test@example.com",
+		When:  time.Date(2024, 1, 1, 0, minute, 0, 0, time.UTC),
+	}
+	commit := &object.Commit{
+		Author:       signature,
+		Committer:    signature,
+		Message:      message,
+		TreeHash:     r.tree,
+		ParentHashes: parents,
+	}
+
+	obj := r.repo.Storer.NewEncodedObject()
+	if err := commit.Encode(obj); err != nil {
+		r.t.Fatal(err)
+	}
+	hash, err := r.repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return hash
+}
+
+// point main and origin/main at the given commits and check out main
+func (r *divergenceRepo) branches(local, upstream plumbing.Hash) {
+	refs := []*plumbing.Reference{
+		plumbing.NewHashReference(plumbing.NewBranchReferenceName("main"), local),
+		plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName("main")),
+	}
+	if !upstream.IsZero() {
+		refs = append(refs, plumbing.NewHashReference(plumbing.NewRemoteReferenceName("origin", "main"), upstream))
+	}
+
+	for _, ref := range refs {
+		if err := r.repo.Storer.SetReference(ref); err != nil {
+			r.t.Fatal(err)
+		}
+	}
+}
+
+func (r *divergenceRepo) expect(wantBehind, wantAhead int) {
+	r.t.Helper()
+
+	behind, ahead, err := compareWithUpstream(r.repo)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	if behind != wantBehind || ahead != wantAhead {
+		r.t.Errorf("got %d behind, %d ahead, want %d behind, %d ahead", behind, ahead, wantBehind, wantAhead)
+	}
+}
+
+func TestCompareWithUpstreamLinear(t *testing.T) {
+	r := newDivergenceRepo(t)
+	base := r.commit("base", 1)
+	base = r.commit("base 2", 2, base)
+	local := r.commit("local", 3, base)
+	upstream := r.commit("upstream", 4, base)
+	upstream = r.commit("upstream 2", 5, upstream)
+
+	r.branches(local, upstream)
+	r.expect(2, 1)
+}
+
+func TestCompareWithUpstreamInSync(t *testing.T) {
+	r := newDivergenceRepo(t)
+	base := r.commit("base", 1)
+	tip := r.commit("tip", 2, base)
+
+	r.branches(tip, tip)
+	r.expect(0, 0)
+}
+
+func TestCompareWithUpstreamMergedSideBranch(t *testing.T) {
+	r := newDivergenceRepo(t)
+	root := r.commit("root", 1)
+	main := r.commit("main", 2, root)
+	side := r.commit("side", 3, root)
+	side = r.commit("side 2", 4, side)
+	merge := r.commit("merge", 5, main, side)
+	upstream := r.commit("upstream", 6, merge)
+	local := r.commit("local", 7, main)
+
+	// merge, both side commits and the commit on top are missing locally
+	r.branches(local, upstream)
+	r.expect(4, 1)
+}
+
+func TestCompareWithUpstreamLocalMerge(t *testing.T) {
+	r := newDivergenceRepo(t)
+	root := r.commit("root", 1)
+	main := r.commit("main", 2, root)
+	side := r.commit("side", 3, root)
+	merge := r.commit("merge", 4, main, side)
+
+	r.branches(merge, main)
+	r.expect(0, 2)
+}
+
+func TestCompareWithUpstreamClockSkew(t *testing.T) {
+	r := newDivergenceRepo(t)
+	shared := r.commit("shared", 50)
+	// committed with a clock running behind, older than its own parent
+	skewed := r.commit("skewed", 5, shared)
+	merged := r.commit("merged", 10, skewed)
+	local := r.commit("local", 300, shared, merged)
+	upstream := r.commit("upstream", 400, merged)
+
+	// shared is reachable from upstream through the skewed commits
+	r.branches(local, upstream)
+	r.expect(1, 1)
+}
+
+func TestCompareWithUpstreamClockSkewOnOneSide(t *testing.T) {
+	r := newDivergenceRepo(t)
+	base := r.commit("base", 100)
+	base = r.commit("base 2", 110, base)
+	local := r.commit("local", 20, base)
+	local = r.commit("local 2", 10, local)
+	upstream := r.commit("upstream", 120, base)
+
+	r.branches(local, upstream)
+	r.expect(1, 2)
+}
+
+func TestCompareWithUpstreamShallow(t *testing.T) {
+	r := newDivergenceRepo(t)
+	// the parent of the oldest commit was cut off by a shallow clone
+	missing := plumbing.NewHash("1111111111111111111111111111111111111111")
+	base := r.commit("base", 1, missing)
+	local := r.commit("local", 2, base)
+	upstream := r.commit("upstream", 3, base)
+	upstream = r.commit("upstream 2", 4, upstream)
+
+	r.branches(local, upstream)
+	r.expect(2, 1)
+}
+
+func TestCompareWithUpstreamShallowDisjoint(t *testing.T) {
+	r := newDivergenceRepo(t)
+	// both tips end at shallow boundaries before their histories meet
+	local := r.commit("local", 2, plumbing.NewHash("1111111111111111111111111111111111111111"))
+	upstream := r.commit("upstream", 3, plumbing.NewHash("2222222222222222222222222222222222222222"))
+
+	r.branches(local, upstream)
+	r.expect(1, 1)
+}
+
+func TestCompareWithUpstreamNoUpstream(t *testing.T) {
+	r := newDivergenceRepo(t)
+	local := r.commit("local", 1)
+
+	r.branches(local, plumbing.ZeroHash)
+	r.expect(0, 0)
+}
+
+func TestCompareWithUpstreamDetachedHead(t *testing.T) {
+	r := newDivergenceRepo(t)
+	base := r.commit("base", 1)
+	local := r.commit("local", 2, base)
+	upstream := r.commit("upstream", 3, base)
+
+	r.branches(local, upstream)
+	if err := r.repo.Storer.SetReference(plumbing.NewHashReference(plumbing.HEAD, local)); err != nil {
+		t.Fatal(err)
+	}
+	r.expect(0, 0)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// RepositoryFilter selects repositories by their path with namespace
+type RepositoryFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// compile include and exclude patterns
+func NewRepositoryFilter(include, exclude []string) (*RepositoryFilter, error) {
+	filter := &RepositoryFilter{}
+
+	for _, pattern := range include {
+		re, err := compilePattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include pattern %q: %w", pattern, err)
+		}
+		filter.include = append(filter.include, re)
+	}
+
+	for _, pattern := range exclude {
+		re, err := compilePattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern %q: %w", pattern, err)
+		}
+		filter.exclude = append(filter.exclude, re)
+	}
+
+	return filter, nil
+}
+
+// compile glob or regex: prefixed pattern
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if expr, ok := strings.CutPrefix(pattern, "regex:"); ok {
+		return regexp.Compile(expr)
+	}
+	return regexp.Compile(globToRegexp(pattern))
+}
+
+// translate glob to anchored regex, ** also matches slashes
+func globToRegexp(glob string) string {
+	var expr strings.Builder
+	expr.WriteString("^")
+
+	for i := 0; i < len(glob); i++ {
+		switch glob[i] {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				expr.WriteString(".*")
+				i++
+			} else {
+				expr.WriteString("[^/]*")
+			}
+		case '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(glob[i])))
+		}
+	}
+
+	expr.WriteString("$")
+	return expr.String()
+}
+
+// check if a repository path passes the filter
+func (f *RepositoryFilter) Match(path string) bool {
+	if len(f.include) > 0 && !matchAny(f.include, path) {
+		return false
+	}
+	return !matchAny(f.exclude, path)
+}
+
+// check path against patterns
+func matchAny(patterns []*regexp.Regexp, path string) bool {
+	for _, re := range patterns {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply filter to repositories
+func (f *RepositoryFilter) Apply(repositories []Repository) []Repository {
+	var filtered []Repository
+	for _, repo := range repositories {
+		if f.Match(repo.PathWithNamespace) {
+			filtered = append(filtered, repo)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"platform/*", "platform/api", true},
+		{"platform/*", "platform/team/api", false},
+		{"platform/**", "platform/team/api", true},
+		{"platform/**", "platformx/y", false},
+		{"platform/**", "platform", false},
+		{"**/api", "platform/team/api", true},
+		{"**/api", "platform/team/api-v2", false},
+		{"platform/ap?", "platform/api", true},
+		{"platform/ap?", "platform/ap/i", false},
+		{"platform/ap?", "platform/apis", false},
+		{"platform/api", "other/platform/api", false},
+		{"group.name/*", "groupxname/api", false},
+		{"regex:.*-archive$", "platform/old-archive", true},
+		{"regex:.*-archive$", "platform/archive-old", false},
+		{"regex:^platform/(api|web)$", "platform/web", true},
+		{"regex:^platform/(api|web)$", "platform/worker", false},
+	}
+
+	for _, test := range tests {
+		re, err := compilePattern(test.pattern)
+		if err != nil {
+			t.Fatalf("%s: %v", test.pattern, err)
+		}
+		if got := re.MatchString(test.path); got != test.want {
+			t.Errorf("%s matching %s: got %t, want %t", test.pattern, test.path, got, test.want)
+		}
+	}
+}
+
+func TestRepositoryFilterExcludeWins(t *testing.T) {
+	filter, err := NewRepositoryFilter([]string{"platform/**"}, []string{"platform/sandbox/**"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]bool{
+		"platform/api":          true,
+		"platform/sandbox/test": false,
+		"platform/sandbox":      true,
+		"other/api":             false,
+	}
+	for path, want := range tests {
+		if got := filter.Match(path); got != want {
+			t.Errorf("%s: got %t, want %t", path, got, want)
+		}
+	}
+}
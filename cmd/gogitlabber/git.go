@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-git/go-git/v6"
 	"github.com/scornet256/go-logger"
@@ -16,17 +19,40 @@ type GitOperationResult struct {
 	Operation string
 	Error     error
 	ErrorType string
+	Duration  time.Duration
+	OldHead   string
+	NewHead   string
+	Retries   int
+	Behind    int
+	Ahead     int
 }
 
+// process exit codes, 1 is also used for fatal errors and 2 for invalid flags
+const (
+	ExitOK          = 0
+	ExitFatal       = 1
+	ExitDirtyRepos  = 3
+	ExitFailedRepos = 4
+	ExitCancelled   = 130
+)
+
 // collect git stats
 type GitStats struct {
 	mu                      sync.Mutex
 	clonedCount             int
 	pulledCount             int
+	fetchedCount            int
 	errorCount              int
+	cancelledCount          int
+	unchangedCount          int
+	gitRetryCount           int
+	apiRetryCount           int
 	pullErrorMsgUnstaged    []string
 	pullErrorMsgUncommitted []string
 	generalErrors           []string
+	divergedRepos           []string
+	results                 []GitOperationResult
+	moves                   []repoMove
 }
 
 // increment counters
@@ -39,6 +65,12 @@ func (stats *GitStats) IncrementCounter(operation string, repoPath string) {
 		stats.clonedCount++
 	case "pulled":
 		stats.pulledCount++
+	case "fetched":
+		stats.fetchedCount++
+	case "cancelled":
+		stats.cancelledCount++
+	case "unchanged":
+		stats.unchangedCount++
 	case "error":
 		stats.errorCount++
 		stats.generalErrors = append(stats.generalErrors, repoPath)
@@ -51,22 +83,132 @@ func (stats *GitStats) IncrementCounter(operation string, repoPath string) {
 	}
 }
 
+// exit code for the collected results, failures outrank local changes
+func (stats *GitStats) ExitCode() int {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	switch {
+	case len(stats.generalErrors) > 0:
+		return ExitFailedRepos
+	case len(stats.pullErrorMsgUnstaged) > 0 || len(stats.pullErrorMsgUncommitted) > 0:
+		return ExitDirtyRepos
+	default:
+		return ExitOK
+	}
+}
+
+// keep result for reporting
+func (stats *GitStats) addResult(result GitOperationResult) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.results = append(stats.results, result)
+	stats.gitRetryCount += result.Retries
+	if result.Behind > 0 || result.Ahead > 0 {
+		stats.divergedRepos = append(stats.divergedRepos,
+			fmt.Sprintf("%s: %d behind, %d ahead", result.RepoName, result.Behind, result.Ahead))
+	}
+}
+
+// add stats of another host, prefixing repository names
+func (stats *GitStats) merge(name string, other *GitStats) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.clonedCount += other.clonedCount
+	stats.pulledCount += other.pulledCount
+	stats.fetchedCount += other.fetchedCount
+	stats.errorCount += other.errorCount
+	stats.cancelledCount += other.cancelledCount
+	stats.unchangedCount += other.unchangedCount
+	stats.moves = append(stats.moves, other.moves...)
+	stats.gitRetryCount += other.gitRetryCount
+	stats.apiRetryCount += other.apiRetryCount
+
+	for _, repo := range other.pullErrorMsgUnstaged {
+		stats.pullErrorMsgUnstaged = append(stats.pullErrorMsgUnstaged, name+": "+repo)
+	}
+	for _, repo := range other.pullErrorMsgUncommitted {
+		stats.pullErrorMsgUncommitted = append(stats.pullErrorMsgUncommitted, name+": "+repo)
+	}
+	for _, repo := range other.generalErrors {
+		stats.generalErrors = append(stats.generalErrors, name+": "+repo)
+	}
+	for _, repo := range other.divergedRepos {
+		stats.divergedRepos = append(stats.divergedRepos, name+": "+repo)
+	}
+}
+
+// gitlab only updates last activity about once an hour
+const lastActivityMargin = time.Hour
+
+// count repositories without activity since their last sync and leave them out
+func skipUnchanged(repositories []Repository, hostState *HostState, stats *GitStats) []Repository {
+	if hostState == nil {
+		return repositories
+	}
+
+	var changed []Repository
+	for _, repo := range repositories {
+		if isUnchanged(repo, hostState) {
+			handleResult(GitOperationResult{RepoName: repo.PathWithNamespace, Operation: "unchanged"}, stats)
+			continue
+		}
+		changed = append(changed, repo)
+	}
+	return changed
+}
+
+// check if a cloned repository had no activity since it was last synced
+func isUnchanged(repo Repository, hostState *HostState) bool {
+	if hostState == nil || repo.LastActivityAt.IsZero() {
+		return false
+	}
+	repoState := hostState.repo(repo.PathWithNamespace)
+	if repoState == nil || repoState.LastSync.IsZero() {
+		return false
+	}
+	if !repo.LastActivityAt.Before(repoState.LastSync.Add(-lastActivityMargin)) {
+		return false
+	}
+
+	// missing repositories still need to be cloned
+	return isGitRepository(localPath(repo.PathWithNamespace))
+}
+
 // concurrent git operations
-func CheckoutRepositories(repositories []Repository, stats *GitStats) {
+func CheckoutRepositories(ctx context.Context, remote *gitRemote, repositories []Repository, stats *GitStats) {
 	var wg sync.WaitGroup
 	semaphore := make(chan struct{}, globalConfig.Concurrency)
 
 	for _, repo := range repositories {
-		wg.Add(1)
-		semaphore <- struct{}{}
+		// stop scheduling new repositories once cancelled
+		if ctx.Err() != nil {
+			handleResult(GitOperationResult{RepoName: repo.PathWithNamespace, Operation: "cancelled"}, stats)
+			continue
+		}
+
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+			handleResult(GitOperationResult{RepoName: repo.PathWithNamespace, Operation: "cancelled"}, stats)
+			continue
+		}
 
+		wg.Add(1)
 		go func(repo Repository) {
 			defer func() {
 				<-semaphore
 				wg.Done()
 			}()
 
-			result := processRepository(repo)
+			result := processRepository(ctx, remote, repo)
+
+			// aborted operations are not failures of the repository
+			if result.Operation == "error" && ctx.Err() != nil {
+				result.Operation = "cancelled"
+			}
 			handleResult(result, stats)
 		}(repo)
 	}
@@ -75,19 +217,41 @@ func CheckoutRepositories(repositories []Repository, stats *GitStats) {
 }
 
 // manage single repo
-func processRepository(repo Repository) GitOperationResult {
+func processRepository(ctx context.Context, remote *gitRemote, repo Repository) (result GitOperationResult) {
 	repoName := string(repo.PathWithNamespace)
-	repoDestination := filepath.Join(globalConfig.Destination, repoName)
+	repoDestination := localPath(repoName)
 
 	logger.Print("Starting on repository: "+repoName, nil)
 
+	// record timing and head movement for the report
+	start := time.Now()
+	oldHead := headHash(repoDestination)
+	defer func() {
+		result.Duration = time.Since(start)
+		result.OldHead = oldHead
+		result.NewHead = headHash(repoDestination)
+	}()
+
+	gitURL, err := remote.url(repo)
+	if err != nil {
+		return GitOperationResult{
+			RepoName:  repoName,
+			Operation: "error",
+			Error:     fmt.Errorf("building remote url: %w", err),
+		}
+	}
+
 	// check if repo exists
-	_, err := git.PlainOpen(repoDestination)
+	_, err = git.PlainOpen(repoDestination)
 	if err != nil {
 		if err == git.ErrRepositoryNotExists {
 			// repo doesn't exist, clone it
-			gitURL := buildGitURL(repoName)
-			return cloneRepository(repoName, repoDestination, gitURL)
+			return retryGitOperation(ctx, remote.retry, repoName, func() GitOperationResult {
+				if remote.mirror {
+					return cloneMirror(ctx, remote, repoName, repoDestination, gitURL)
+				}
+				return cloneRepository(ctx, remote, repoName, repoDestination, gitURL)
+			})
 		}
 		return GitOperationResult{
 			RepoName:  repoName,
@@ -97,17 +261,34 @@ func processRepository(repo Repository) GitOperationResult {
 	}
 
 	// repo exists, pull it
-	return pullRepository(repoName, repoDestination)
+	return retryGitOperation(ctx, remote.retry, repoName, func() GitOperationResult {
+		if remote.mirror {
+			return fetchMirror(ctx, remote, repoName, repoDestination, gitURL)
+		}
+		if remote.fetchOnly {
+			return fetchRepository(ctx, remote, repoName, repoDestination, gitURL)
+		}
+		return pullRepository(ctx, remote, repoName, repoDestination, gitURL)
+	})
 }
 
-// craft git url with auth embedded (for remote URL storage)
-func buildGitURL(repoName string) string {
-	return fmt.Sprintf("https://%s-token:%s@%s/%s.git",
-		globalConfig.GitBackend, globalConfig.GitToken, globalConfig.GitHost, repoName)
+// retry clones and pulls that failed for transient reasons, local changes are never retried
+func retryGitOperation(ctx context.Context, policy RetryPolicy, repoName string, operation func() GitOperationResult) GitOperationResult {
+	var result GitOperationResult
+	retries, _ := policy.do(ctx, repoName, func() error {
+		result = operation()
+		if result.Operation == "error" && result.ErrorType != "unstaged" && result.ErrorType != "uncommitted" {
+			return result.Error
+		}
+		return nil
+	})
+
+	result.Retries = retries
+	return result
 }
 
 // clone new repository
-func cloneRepository(repoName, repoDestination, gitURL string) GitOperationResult {
+func cloneRepository(ctx context.Context, remote *gitRemote, repoName, repoDestination, gitURL string) GitOperationResult {
 	logger.Print("Cloning repository: "+repoName, nil)
 
 	// ensure parent directory exists
@@ -120,12 +301,39 @@ func cloneRepository(repoName, repoDestination, gitURL string) GitOperationResul
 		}
 	}
 
-	_, err := git.PlainClone(repoDestination, &git.CloneOptions{
-		URL:      gitURL,
-		Progress: nil,
+	settings := remote.cloneSettings(repoName)
+	filter, err := settings.packFilter()
+	if err != nil {
+		return GitOperationResult{
+			RepoName:  repoName,
+			Operation: "error",
+			Error:     err,
+		}
+	}
+
+	_, statErr := os.Stat(repoDestination)
+	existed := statErr == nil
+
+	repo, err := git.PlainCloneContext(ctx, repoDestination, &git.CloneOptions{
+		URL:           gitURL,
+		ClientOptions: remote.clientOptions,
+		Depth:         settings.Depth,
+		SingleBranch:  settings.SingleBranch,
+		Filter:        filter,
+		NoCheckout:    filter != "",
+		Progress:      nil,
 	})
 
+	// missing objects of partial clones are fetched by git itself
+	if err == nil && filter != "" {
+		err = checkoutPartialClone(ctx, remote, repo, repoDestination, settings.Filter)
+	}
+
 	if err != nil {
+		// don't leave half populated directories behind
+		if !existed {
+			removePartialClone(repoDestination)
+		}
 		return GitOperationResult{
 			RepoName:  repoName,
 			Operation: "error",
@@ -145,25 +353,18 @@ func cloneRepository(repoName, repoDestination, gitURL string) GitOperationResul
 }
 
 // pull repo
-func pullRepository(repoName, repoDestination string) GitOperationResult {
+func pullRepository(ctx context.Context, remote *gitRemote, repoName, repoDestination, gitURL string) GitOperationResult {
 	logger.Print("Pulling repository: "+repoName, nil)
 
-	// open repository
-	repo, err := git.PlainOpen(repoDestination)
+	repo, depth, err := openCheckout(remote, repoName, repoDestination, gitURL)
 	if err != nil {
 		return GitOperationResult{
 			RepoName:  repoName,
 			Operation: "error",
-			Error:     fmt.Errorf("opening repository: %w", err),
+			Error:     err,
 		}
 	}
 
-	// update remote URL with current token (in case token changed)
-	gitURL := buildGitURL(repoName)
-	if err := updateRemoteURL(repoDestination, gitURL); err != nil {
-		logger.Print("WARNING: failed to update remote URL: "+err.Error(), nil)
-	}
-
 	// get worktree
 	worktree, err := repo.Worktree()
 	if err != nil {
@@ -184,16 +385,7 @@ func pullRepository(repoName, repoDestination string) GitOperationResult {
 		}
 	}
 
-	if !status.IsClean() {
-		// determine error type
-		errorType := "unstaged"
-		for _, s := range status {
-			if s.Staging != git.Unmodified {
-				errorType = "uncommitted"
-				break
-			}
-		}
-
+	if errorType := localChanges(status); errorType != "" {
 		return GitOperationResult{
 			RepoName:  repoName,
 			Operation: "error",
@@ -203,8 +395,11 @@ func pullRepository(repoName, repoDestination string) GitOperationResult {
 	}
 
 	// pull changes
-	err = worktree.Pull(&git.PullOptions{
-		Progress: nil,
+	err = worktree.PullContext(ctx, &git.PullOptions{
+		ClientOptions: remote.clientOptions,
+		Depth:         depth,
+		SingleBranch:  remote.cloneSettings(repoName).SingleBranch,
+		Progress:      nil,
 	})
 
 	if err != nil {
@@ -221,6 +416,8 @@ func pullRepository(repoName, repoDestination string) GitOperationResult {
 		}
 	}
 
+	markFetchedPacks(repo, repoDestination)
+
 	// set git user configuration
 	if err := setGitUserConfig(repoName, repoDestination); err != nil {
 		logger.Print("WARNING: failed to set git user config: "+err.Error(), nil)
@@ -232,6 +429,76 @@ func pullRepository(repoName, repoDestination string) GitOperationResult {
 	}
 }
 
+// open a checkout for pulling or fetching and point its remote at gitURL,
+// the returned depth keeps shallow clones shallow instead of fetching the whole history
+func openCheckout(remote *gitRemote, repoName, repoDestination, gitURL string) (*git.Repository, int, error) {
+	repo, err := git.PlainOpen(repoDestination)
+	if err != nil {
+		return nil, 0, fmt.Errorf("opening repository: %w", err)
+	}
+
+	// update remote URL, this also drops tokens stored by older versions
+	if err := updateRemoteURL(repoDestination, gitURL); err != nil {
+		logger.Print("WARNING: failed to update remote URL: "+err.Error(), nil)
+	}
+
+	depth := 0
+	if shallow, err := repo.Storer.Shallow(); err == nil && len(shallow) > 0 {
+		depth = remote.cloneSettings(repoName).Depth
+	}
+
+	return repo, depth, nil
+}
+
+// classify local changes as unstaged or uncommitted, empty when clean
+func localChanges(status git.Status) string {
+	if status.IsClean() {
+		return ""
+	}
+
+	for _, s := range status {
+		if s.Staging != git.Unmodified {
+			return "uncommitted"
+		}
+	}
+
+	return "unstaged"
+}
+
+// remove a failed clone and the empty parent directories created for it
+func removePartialClone(repoDestination string) {
+	if err := os.RemoveAll(repoDestination); err != nil {
+		logger.Print("WARNING: failed to remove partial clone: "+err.Error(), nil)
+	}
+	removeEmptyParents(repoDestination)
+}
+
+// remove parent directories left empty inside the destination
+func removeEmptyParents(path string) {
+	destination := filepath.Clean(globalConfig.Destination)
+	for dir := filepath.Dir(path); dir != destination && strings.HasPrefix(dir, destination); dir = filepath.Dir(dir) {
+		// fails when other repositories live below it
+		if err := os.Remove(dir); err != nil {
+			break
+		}
+	}
+}
+
+// current head commit of a repository, empty if unknown
+func headHash(repoDestination string) string {
+	repo, err := git.PlainOpen(repoDestination)
+	if err != nil {
+		return ""
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return ""
+	}
+
+	return head.Hash().String()
+}
+
 // set git user config
 func setGitUserConfig(repoName, repoDestination string) error {
 	repo, err := git.PlainOpen(repoDestination)
@@ -255,7 +522,7 @@ func setGitUserConfig(repoName, repoDestination string) error {
 	return nil
 }
 
-// update remote URL with current token
+// update remote URL
 func updateRemoteURL(repoDestination, gitURL string) error {
 	repo, err := git.PlainOpen(repoDestination)
 	if err != nil {
@@ -280,8 +547,38 @@ func updateRemoteURL(repoDestination, gitURL string) error {
 	return nil
 }
 
+// find git repositories below root
+func findGitRepositories(root string) ([]string, error) {
+	var repoPaths []string
+
+	err := filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+
+		// checkouts and mirrors are repositories, don't descend into them
+		if isGitRepository(path) {
+			repoPaths = append(repoPaths, path)
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	return repoPaths, err
+}
+
 // manage results
 func handleResult(result GitOperationResult, stats *GitStats) {
+	stats.addResult(result)
+
 	switch result.Operation {
 	case "cloned":
 		stats.IncrementCounter("cloned", "")
@@ -291,6 +588,18 @@ func handleResult(result GitOperationResult, stats *GitStats) {
 		stats.IncrementCounter("pulled", "")
 		logger.Print("Successfully pulled: "+result.RepoName, nil)
 
+	case "fetched":
+		stats.IncrementCounter("fetched", "")
+		logger.Print("Successfully fetched: "+result.RepoName, nil)
+
+	case "cancelled":
+		stats.IncrementCounter("cancelled", result.RepoName)
+		logger.Print("Cancelled: "+result.RepoName, nil)
+
+	case "unchanged":
+		stats.IncrementCounter("unchanged", result.RepoName)
+		logger.Print("No activity since last sync: "+result.RepoName, nil)
+
 	case "error":
 		switch result.ErrorType {
 		case "unstaged":
@@ -303,12 +612,12 @@ func handleResult(result GitOperationResult, stats *GitStats) {
 
 		default:
 			stats.IncrementCounter("error", result.RepoName)
-			logger.Print("ERROR processing "+result.RepoName+": "+result.Error.Error(), nil)
+			logger.Print("ERROR processing "+result.RepoName+": "+redactToken(result.Error.Error(), globalConfig.GitToken), nil)
 		}
 	}
 
 	// update progress bar
-	if !globalConfig.Debug {
+	if !globalConfig.Debug && bar != nil {
 		_ = bar.Add(1)
 	}
 }
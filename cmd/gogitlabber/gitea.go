@@ -2,32 +2,64 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/scornet256/go-logger"
 )
 
 // giteaClient struct
 type GiteaClient struct {
-	httpClient *http.Client
-	baseURL    string
-	token      string
+	apiClient
+	flavor          string
+	capabilities    GiteaCapabilities
+	orgs            []string
+	admin           bool
+	owners          []string
+	topics          []string
+	includeArchived string
 }
 
 // gitea repo information
 type GiteaRepository struct {
-	Name     string `json:"name"`
-	FullName string `json:"full_name"`
+	ID       int      `json:"id"`
+	Name     string   `json:"name"`
+	FullName string   `json:"full_name"`
+	Archived bool     `json:"archived"`
+	SSHURL   string   `json:"ssh_url"`
+	Topics   []string `json:"topics"`
+	Owner    struct {
+		Login string `json:"login"`
+	} `json:"owner"`
 }
 
+// gitea repo search response
+type GiteaSearchResult struct {
+	OK   bool              `json:"ok"`
+	Data []GiteaRepository `json:"data"`
+}
+
+// gitea compatible server features
+type GiteaCapabilities struct {
+	Version    string
+	Pagination bool // page and limit are honoured
+	LinkHeader bool // further pages are announced in the Link header
+	Archived   bool // repositories carry an archived flag
+}
+
+// gitea api endpoints listing repositories
+const (
+	giteaUserReposPath = "/user/repos"
+	giteaSearchPath    = "/repos/search"
+)
+
 // gitea api options
 type GiteaAPIOptions struct {
+	Endpoint        string
 	Visibility      string
 	IncludeArchived string
 	Sort            string
@@ -35,44 +67,151 @@ type GiteaAPIOptions struct {
 	Page            int
 }
 
+// register backend
+func init() {
+	registerProvider("gitea", newGiteaProvider("gitea"))
+	registerProvider("forgejo", newGiteaProvider("forgejo"))
+	registerProvider("gogs", newGiteaProvider("gogs"))
+}
+
 // gitea api client
 func NewGiteaClient(baseURL, token string) *GiteaClient {
 	return &GiteaClient{
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-		baseURL: baseURL,
-		token:   token,
+		apiClient: newAPIClient(baseURL, token, func(req *http.Request, token string) {
+			req.Header.Set("Authorization", fmt.Sprintf("token %s", token))
+		}),
+		flavor:       "gitea",
+		capabilities: giteaDefaultCapabilities("gitea"),
+	}
+}
+
+// gitea compatible provider from config
+func newGiteaProvider(flavor string) providerFactory {
+	return func(conf *Config) Provider {
+		client := NewGiteaClient(conf.GitHost, conf.GitToken)
+		client.flavor = flavor
+		client.capabilities = giteaDefaultCapabilities(flavor)
+		client.orgs = conf.GiteaOrgs
+		client.admin = conf.GiteaAdmin
+		client.owners = conf.GiteaOwners
+		client.topics = conf.GiteaTopics
+		client.includeArchived = conf.IncludeArchived
+		client.configure(conf)
+		return client
+	}
+}
+
+// assumed features when the server does not report its version
+func giteaDefaultCapabilities(flavor string) GiteaCapabilities {
+	if flavor == "gogs" {
+		// gogs returns all repositories at once and has no archived flag
+		return GiteaCapabilities{}
+	}
+	return GiteaCapabilities{Pagination: true, LinkHeader: true, Archived: true}
+}
+
+// features of a server by flavor and reported version
+func giteaCapabilitiesFromVersion(flavor, version string) GiteaCapabilities {
+	capabilities := giteaDefaultCapabilities(flavor)
+	capabilities.Version = version
+
+	// forgejo forked off a gitea that had everything, gogs never got it
+	if flavor != "gitea" {
+		return capabilities
+	}
+
+	major, minor, ok := parseMajorMinor(version)
+	if !ok {
+		return capabilities
+	}
+
+	// archiving arrived in gitea 1.8, Link headers on list endpoints in 1.12
+	capabilities.Archived = major > 1 || minor >= 8
+	capabilities.LinkHeader = major > 1 || minor >= 12
+	return capabilities
+}
+
+// parse the leading major and minor number of a version like 1.21.4+dev-12
+func parseMajorMinor(version string) (int, int, bool) {
+	parts := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, false
+	}
+
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
 	}
+
+	minorDigits := strings.IndexFunc(parts[1], func(r rune) bool { return r < '0' || r > '9' })
+	if minorDigits >= 0 {
+		parts[1] = parts[1][:minorDigits]
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return major, minor, true
 }
 
 // fetch gitea repos
-func FetchRepositoriesGitea() ([]Repository, error) {
-	client := NewGiteaClient(globalConfig.GitHost, globalConfig.GitToken)
+func (c *GiteaClient) FetchRepositories(ctx context.Context) ([]Repository, error) {
 	options := GiteaAPIOptions{
 		Visibility:      "all",
-		IncludeArchived: globalConfig.IncludeArchived,
+		IncludeArchived: c.includeArchived,
 		Sort:            "alpha",
 		Limit:           100,
 		Page:            1,
 	}
 
-	repositories, err := client.fetchAllRepositories(context.Background(), options)
-	if err != nil {
-		return nil, fmt.Errorf("fetching repositories: %w", err)
+	switch {
+	case c.admin:
+		// every repository on the instance, needs an admin token
+		options.Endpoint = giteaSearchPath
+		return c.fetchAllRepositories(ctx, options)
+	case len(c.orgs) > 0:
+		return c.fetchOrgsRepositories(ctx, options)
+	default:
+		options.Endpoint = giteaUserReposPath
+		return c.fetchAllRepositories(ctx, options)
 	}
+}
 
-	if len(repositories) == 0 {
-		return repositories, fmt.Errorf("no repositories found")
-	}
+// fetch repositories of configured organizations without duplicates
+func (c *GiteaClient) fetchOrgsRepositories(ctx context.Context, options GiteaAPIOptions) ([]Repository, error) {
+	var allRepositories []Repository
+	seen := map[string]bool{}
 
-	// update progress bar
-	if err := updateProgressBar(len(repositories)); err != nil {
-		logger.Print("WARNING: failed to update progress bar: "+err.Error(), nil)
+	for _, org := range c.orgs {
+		options.Endpoint = "/orgs/" + url.PathEscape(org) + "/repos"
+		options.Page = 1
+
+		repositories, err := c.fetchAllRepositories(ctx, options)
+		if err != nil {
+			return nil, fmt.Errorf("fetching organization %s: %w", org, err)
+		}
+
+		for _, repo := range repositories {
+			if seen[repo.PathWithNamespace] {
+				continue
+			}
+			seen[repo.PathWithNamespace] = true
+			allRepositories = append(allRepositories, repo)
+		}
 	}
 
-	logger.Print(fmt.Sprintf("Successfully fetched %d repositories", len(repositories)), nil)
-	return repositories, nil
+	return allRepositories, nil
+}
+
+// craft git url without credentials
+func (c *GiteaClient) CloneURL(repo Repository) string {
+	return httpsCloneURL(c.baseURL, repo.PathWithNamespace)
+}
+
+// gitea, forgejo and gogs accept any username together with a token
+func (c *GiteaClient) Credentials() (string, string) {
+	return c.flavor + "-token", c.token
 }
 
 // fetch all repos with pagination
@@ -85,11 +224,21 @@ func (c *GiteaClient) fetchAllRepositories(ctx context.Context, options GiteaAPI
 			return nil, fmt.Errorf("fetching page %d: %w", options.Page, err)
 		}
 
+		// servers without Link headers have more pages as long as pages come back full
+		if !c.capabilities.LinkHeader {
+			hasMore = len(giteaRepos) > 0 && len(giteaRepos) >= options.Limit
+		}
+
 		// convert gitea repositories to repo type
-		repositories := convertGiteaRepositories(giteaRepos)
+		includeArchived := options.IncludeArchived
+		if !c.capabilities.Archived {
+			includeArchived = "any"
+		}
+		giteaRepos = filterGiteaRepositories(giteaRepos, c.owners, c.topics)
+		repositories := convertGiteaRepositories(giteaRepos, includeArchived)
 		allRepositories = append(allRepositories, repositories...)
 
-		if !hasMore {
+		if !hasMore || !c.capabilities.Pagination {
 			break
 		}
 
@@ -106,44 +255,33 @@ func (c *GiteaClient) fetchRepositoryPage(ctx context.Context, options GiteaAPIO
 		return nil, false, fmt.Errorf("building API URL: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
-	if err != nil {
-		return nil, false, fmt.Errorf("creating request: %w", err)
+	var giteaRepos []GiteaRepository
+	var headers http.Header
+	if options.Endpoint == giteaSearchPath {
+		// search wraps the repositories in a result object
+		var result GiteaSearchResult
+		headers, err = c.getJSON(ctx, apiURL, &result)
+		giteaRepos = result.Data
+	} else {
+		headers, err = c.getJSON(ctx, apiURL, &giteaRepos)
 	}
-
-	req.Header.Set("Authorization", fmt.Sprintf("token %s", c.token))
-	req.Header.Set("Accept", "application/json")
-
-	logger.Print("Making API request to: "+apiURL, nil)
-
-	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, false, fmt.Errorf("making request: %w", err)
-	}
-	defer func() {
-		if closeErr := resp.Body.Close(); closeErr != nil {
-			logger.Print("WARNING: failed to close response body: "+closeErr.Error(), nil)
-		}
-	}()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, false, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, resp.Status)
-	}
-
-	var giteaRepos []GiteaRepository
-	if err := json.NewDecoder(resp.Body).Decode(&giteaRepos); err != nil {
-		return nil, false, fmt.Errorf("decoding JSON response: %w", err)
+		return nil, false, err
 	}
 
 	// check for more pages
-	hasMore := strings.Contains(resp.Header.Get("Link"), `rel="next"`)
+	hasMore := strings.Contains(headers.Get("Link"), `rel="next"`)
 
 	return giteaRepos, hasMore, nil
 }
 
 // build api url
 func (c *GiteaClient) buildAPIURL(options GiteaAPIOptions) (string, error) {
-	baseURL := fmt.Sprintf("https://%s/api/v1/user/repos", c.baseURL)
+	endpoint := options.Endpoint
+	if endpoint == "" {
+		endpoint = giteaUserReposPath
+	}
+	baseURL := fmt.Sprintf("https://%s/api/v1%s", c.baseURL, endpoint)
 
 	u, err := url.Parse(baseURL)
 	if err != nil {
@@ -157,60 +295,110 @@ func (c *GiteaClient) buildAPIURL(options GiteaAPIOptions) (string, error) {
 	query.Set("page", strconv.Itoa(options.Page))
 
 	// handle archived
-	switch options.IncludeArchived {
-	case "excluded":
-		query.Set("archived", "false")
-	case "only":
-		query.Set("archived", "true")
+	if c.capabilities.Archived {
+		switch options.IncludeArchived {
+		case "excluded":
+			query.Set("archived", "false")
+		case "only":
+			query.Set("archived", "true")
+		}
 	}
 
 	u.RawQuery = query.Encode()
 	return u.String(), nil
 }
 
+// keep repositories of wanted owners carrying wanted topics
+func filterGiteaRepositories(giteaRepos []GiteaRepository, owners, topics []string) []GiteaRepository {
+	if len(owners) == 0 && len(topics) == 0 {
+		return giteaRepos
+	}
+
+	var filtered []GiteaRepository
+	for _, giteaRepo := range giteaRepos {
+		if len(owners) > 0 && !slices.Contains(owners, giteaRepo.Owner.Login) {
+			continue
+		}
+		if len(topics) > 0 && !slices.ContainsFunc(giteaRepo.Topics, func(topic string) bool {
+			return slices.Contains(topics, topic)
+		}) {
+			continue
+		}
+		filtered = append(filtered, giteaRepo)
+	}
+
+	return filtered
+}
+
 // convert gitea repos to repo type
-func convertGiteaRepositories(giteaRepos []GiteaRepository) []Repository {
-	repositories := make([]Repository, len(giteaRepos))
-	for i, giteaRepo := range giteaRepos {
-		repositories[i] = Repository{
+func convertGiteaRepositories(giteaRepos []GiteaRepository, includeArchived string) []Repository {
+	var repositories []Repository
+
+	for _, giteaRepo := range giteaRepos {
+		if includeArchived == "excluded" && giteaRepo.Archived {
+			continue
+		}
+		if includeArchived == "exclusive" && !giteaRepo.Archived {
+			continue
+		}
+
+		repositories = append(repositories, Repository{
+			ID:                giteaRepo.ID,
 			Name:              giteaRepo.Name,
 			PathWithNamespace: giteaRepo.FullName,
-		}
+			SSHURL:            giteaRepo.SSHURL,
+		})
 	}
+
 	return repositories
 }
 
 // connection validation
 func (c *GiteaClient) ValidateConnection(ctx context.Context) error {
-	apiURL := fmt.Sprintf("https://%s/api/v1/user", c.baseURL)
-
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
-	if err != nil {
-		return fmt.Errorf("creating validation request: %w", err)
+	if err := c.validate(ctx, fmt.Sprintf("https://%s/api/v1/user", c.baseURL)); err != nil {
+		return err
 	}
 
-	req.Header.Set("Authorization", fmt.Sprintf("token %s", c.token))
-	req.Header.Set("Accept", "application/json")
+	c.detectCapabilities(ctx)
+	return nil
+}
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("making validation request: %w", err)
-	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			logger.Print("failed to close response body: %v", err)
-		}
-	}()
+// detect server features from the reported version
+func (c *GiteaClient) detectCapabilities(ctx context.Context) {
+	apiURL := fmt.Sprintf("https://%s/api/v1/version", c.baseURL)
 
-	if resp.StatusCode == http.StatusUnauthorized {
-		return fmt.Errorf("invalid or expired token")
+	var version struct {
+		Version string `json:"version"`
+	}
+	if _, err := c.getJSON(ctx, apiURL, &version); err != nil {
+		logger.Print(fmt.Sprintf("Could not detect %s version, assuming defaults: %s", c.flavor, err.Error()), nil)
+		return
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("API validation failed with status %d", resp.StatusCode)
+	flavor := giteaFlavorFromVersion(version.Version, c.flavor)
+	if flavor != c.flavor {
+		logger.Print(fmt.Sprintf("WARNING: server at %s looks like %s, configured as %s", c.baseURL, flavor, c.flavor), nil)
 	}
+	c.capabilities = giteaCapabilitiesFromVersion(flavor, version.Version)
 
-	return nil
+	logger.Print(fmt.Sprintf("Detected %s version %s (pagination: %t, link header: %t, archived filter: %t)",
+		flavor, version.Version, c.capabilities.Pagination, c.capabilities.LinkHeader, c.capabilities.Archived), nil)
+}
+
+// guess the server flavor from its version string
+func giteaFlavorFromVersion(version, configured string) string {
+	switch {
+	case strings.Contains(version, "+gitea-"), strings.Contains(strings.ToLower(version), "forgejo"):
+		// forgejo reports its own version followed by the compatible gitea version
+		return "forgejo"
+	case configured == "gogs" && strings.HasPrefix(version, "0."):
+		return "gogs"
+	case configured == "forgejo":
+		// older forgejo releases report a plain gitea version
+		return "forgejo"
+	default:
+		return "gitea"
+	}
 }
 
 // simply count git repos only
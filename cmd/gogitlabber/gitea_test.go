@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestGiteaCapabilitiesFromVersion(t *testing.T) {
+	tests := []struct {
+		flavor  string
+		version string
+		want    GiteaCapabilities
+	}{
+		{"gitea", "1.7.6", GiteaCapabilities{Pagination: true}},
+		{"gitea", "1.11.8", GiteaCapabilities{Pagination: true, Archived: true}},
+		{"gitea", "1.12.0", GiteaCapabilities{Pagination: true, LinkHeader: true, Archived: true}},
+		{"gitea", "1.22.0+dev-123-gabcdef", GiteaCapabilities{Pagination: true, LinkHeader: true, Archived: true}},
+		{"gitea", "development", GiteaCapabilities{Pagination: true, LinkHeader: true, Archived: true}},
+		{"forgejo", "7.0.0+gitea-1.21.0", GiteaCapabilities{Pagination: true, LinkHeader: true, Archived: true}},
+		{"gogs", "0.13.0", GiteaCapabilities{}},
+	}
+
+	for _, test := range tests {
+		got := giteaCapabilitiesFromVersion(test.flavor, test.version)
+		test.want.Version = test.version
+		if got != test.want {
+			t.Errorf("%s %s: got %+v, want %+v", test.flavor, test.version, got, test.want)
+		}
+	}
+}
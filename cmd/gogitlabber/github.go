@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/scornet256/go-logger"
+)
+
+// GitHubClient encapsulates the GitHub API client functionality
+type GitHubClient struct {
+	apiClient
+	gitHost         string
+	includeArchived string
+}
+
+// github repo information
+type GitHubRepository struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+	Archived bool   `json:"archived"`
+	SSHURL   string `json:"ssh_url"`
+}
+
+// github api options
+type GitHubAPIOptions struct {
+	Affiliation string
+	Sort        string
+	PerPage     int
+}
+
+// register backend
+func init() {
+	registerProvider("github", newGitHubProvider)
+}
+
+// github api client
+func NewGitHubClient(apiBase, gitHost, token string) *GitHubClient {
+	return &GitHubClient{
+		apiClient: newAPIClient(strings.TrimSuffix(apiBase, "/"), token, func(req *http.Request, token string) {
+			req.Header.Set("Authorization", "Bearer "+token)
+			req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+		}),
+		gitHost: gitHost,
+	}
+}
+
+// github provider from config
+func newGitHubProvider(conf *Config) Provider {
+	client := NewGitHubClient(githubAPIBase(conf), conf.GitHost, conf.GitToken)
+	client.includeArchived = conf.IncludeArchived
+	client.configure(conf)
+	return client
+}
+
+// api base for github.com or github enterprise
+func githubAPIBase(conf *Config) string {
+	if conf.APIBase != "" {
+		return conf.APIBase
+	}
+	if conf.GitHost == "github.com" {
+		return "https://api.github.com"
+	}
+	return fmt.Sprintf("https://%s/api/v3", conf.GitHost)
+}
+
+// fetch github repos
+func (c *GitHubClient) FetchRepositories(ctx context.Context) ([]Repository, error) {
+	options := GitHubAPIOptions{
+		Affiliation: "owner,collaborator,organization_member",
+		Sort:        "full_name",
+		PerPage:     100,
+	}
+
+	return c.fetchAllRepositories(ctx, options)
+}
+
+// craft git url without credentials
+func (c *GitHubClient) CloneURL(repo Repository) string {
+	return httpsCloneURL(c.gitHost, repo.PathWithNamespace)
+}
+
+// github accepts any username together with a token
+func (c *GitHubClient) Credentials() (string, string) {
+	return "x-access-token", c.token
+}
+
+// connection validation
+func (c *GitHubClient) ValidateConnection(ctx context.Context) error {
+	return c.validate(ctx, c.baseURL+"/user")
+}
+
+// fetch all repos with pagination
+func (c *GitHubClient) fetchAllRepositories(ctx context.Context, options GitHubAPIOptions) ([]Repository, error) {
+	var allRepositories []Repository
+
+	apiURL, err := c.buildAPIURL(options)
+	if err != nil {
+		return nil, fmt.Errorf("building API URL: %w", err)
+	}
+
+	for page := 1; apiURL != ""; page++ {
+		var githubRepos []GitHubRepository
+		headers, err := c.getJSON(ctx, apiURL, &githubRepos)
+		if err != nil {
+			return nil, fmt.Errorf("fetching page %d: %w", page, err)
+		}
+
+		// convert github repositories to repo type
+		repositories := convertGitHubRepositories(githubRepos, c.includeArchived)
+		allRepositories = append(allRepositories, repositories...)
+
+		logger.Print(fmt.Sprintf("Fetched page %d (%d repositories)", page, len(githubRepos)), nil)
+
+		// follow next link if there are more pages
+		apiURL = parseLinkHeader(headers.Get("Link"))["next"]
+	}
+
+	return allRepositories, nil
+}
+
+// build api url
+func (c *GitHubClient) buildAPIURL(options GitHubAPIOptions) (string, error) {
+	u, err := url.Parse(c.baseURL + "/user/repos")
+	if err != nil {
+		return "", fmt.Errorf("parsing base URL: %w", err)
+	}
+
+	query := u.Query()
+	query.Set("affiliation", options.Affiliation)
+	query.Set("sort", options.Sort)
+	query.Set("per_page", strconv.Itoa(options.PerPage))
+
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// convert github repos to repo type
+func convertGitHubRepositories(githubRepos []GitHubRepository, includeArchived string) []Repository {
+	var repositories []Repository
+
+	for _, githubRepo := range githubRepos {
+		// github has no archived filter on this endpoint
+		if includeArchived == "excluded" && githubRepo.Archived {
+			continue
+		}
+		if includeArchived == "exclusive" && !githubRepo.Archived {
+			continue
+		}
+
+		repositories = append(repositories, Repository{
+			ID:                githubRepo.ID,
+			Name:              githubRepo.Name,
+			PathWithNamespace: githubRepo.FullName,
+			SSHURL:            githubRepo.SSHURL,
+		})
+	}
+
+	return repositories
+}
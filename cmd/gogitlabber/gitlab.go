@@ -2,11 +2,12 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/scornet256/go-logger"
@@ -14,9 +15,10 @@ import (
 
 // GitLabClient encapsulates the GitLab API client functionality
 type GitLabClient struct {
-	httpClient *http.Client
-	baseURL    string
-	token      string
+	apiClient
+	groups          []string
+	withShared      bool
+	includeArchived string
 }
 
 // GitLabProject represents a project from GitLab API
@@ -28,6 +30,7 @@ type GitLabProject struct {
 	Archived          bool   `json:"archived"`
 	LastActivityAt    string `json:"last_activity_at"`
 	WebURL            string `json:"web_url"`
+	SSHURLToRepo      string `json:"ssh_url_to_repo"`
 }
 
 // GitLabAPIOptions holds the API request parameters
@@ -39,6 +42,9 @@ type GitLabAPIOptions struct {
 	PerPage         int
 	Page            int
 	MinAccessLevel  int // 10=Guest, 20=Reporter, 30=Developer, 40=Maintainer, 50=Owner
+	IncludeSubgroup bool
+	WithShared      bool
+	Keyset          bool
 }
 
 // gitlab pagination info
@@ -50,23 +56,41 @@ type GitLabPaginationInfo struct {
 	PreviousPage int
 }
 
+// pages fetched at the same time once the page count is known
+const gitlabPageWorkers = 4
+
+// fetches a single page of projects
+type gitlabPageFetcher func(ctx context.Context, options GitLabAPIOptions) ([]GitLabProject, GitLabPaginationInfo, error)
+
+// register backend
+func init() {
+	registerProvider("gitlab", newGitLabProvider)
+}
+
 // gitlab client
 func NewGitLabClient(baseURL, token string) *GitLabClient {
 	return &GitLabClient{
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-		baseURL: baseURL,
-		token:   token,
+		apiClient: newAPIClient(baseURL, token, func(req *http.Request, token string) {
+			req.Header.Set("PRIVATE-TOKEN", token)
+		}),
 	}
 }
 
+// gitlab provider from config
+func newGitLabProvider(conf *Config) Provider {
+	client := NewGitLabClient(conf.GitHost, conf.GitToken)
+	client.groups = conf.GitLabGroups
+	client.withShared = conf.GitLabWithShared
+	client.includeArchived = conf.IncludeArchived
+	client.configure(conf)
+	return client
+}
+
 // fetch gitlab repos
-func FetchRepositoriesGitLab() ([]Repository, error) {
-	client := NewGitLabClient(globalConfig.GitHost, globalConfig.GitToken)
+func (c *GitLabClient) FetchRepositories(ctx context.Context) ([]Repository, error) {
 	options := GitLabAPIOptions{
 		Membership:      true,
-		IncludeArchived: globalConfig.IncludeArchived,
+		IncludeArchived: c.includeArchived,
 		OrderBy:         "name",
 		Sort:            "asc",
 		PerPage:         100,
@@ -74,90 +98,184 @@ func FetchRepositoriesGitLab() ([]Repository, error) {
 		MinAccessLevel:  20,
 	}
 
-	repositories, err := client.fetchAllProjects(context.Background(), options)
-	if err != nil {
-		return nil, fmt.Errorf("fetching repositories: %w", err)
-	}
-
-	if len(repositories) == 0 {
-		return repositories, fmt.Errorf("no repositories found")
-	}
-
-	// update progress bar
-	if err := updateProgressBar(len(repositories)); err != nil {
-		logger.Print("WARNING: failed to update progress bar: "+err.Error(), nil)
+	if len(c.groups) > 0 {
+		options.Membership = false
+		options.IncludeSubgroup = true
+		options.WithShared = c.withShared
+		return c.fetchGroupsProjects(ctx, options)
 	}
 
-	logger.Print(fmt.Sprintf("Successfully fetched %d repositories", len(repositories)), nil)
-	return repositories, nil
+	return c.fetchAllProjects(ctx, options)
 }
 
-// fetch all repos with pagination
-func (c *GitLabClient) fetchAllProjects(ctx context.Context, options GitLabAPIOptions) ([]Repository, error) {
+// fetch projects of configured groups without duplicates
+func (c *GitLabClient) fetchGroupsProjects(ctx context.Context, options GitLabAPIOptions) ([]Repository, error) {
 	var allRepositories []Repository
+	seen := map[string]bool{}
 
-	for {
-		gitlabProjects, pagination, err := c.fetchProjectPage(ctx, options)
+	for _, group := range c.groups {
+		repositories, err := c.GetProjectsByGroup(ctx, group, options)
 		if err != nil {
-			return nil, fmt.Errorf("fetching page %d: %w", options.Page, err)
+			return nil, fmt.Errorf("fetching group %s: %w", group, err)
 		}
 
-		// convert gitlab repositories to repo type
-		repositories := convertGitLabProjects(gitlabProjects, options.IncludeArchived)
-		allRepositories = append(allRepositories, repositories...)
-
-		logger.Print(fmt.Sprintf("Fetched page %d/%d (%d projects)",
-			pagination.CurrentPage, pagination.TotalPages, len(gitlabProjects)), nil)
-
-		// check if we have more pages
-		if pagination.NextPage == 0 || pagination.CurrentPage >= pagination.TotalPages {
-			break
+		// groups can overlap through subgroups and sharing
+		for _, repo := range repositories {
+			if seen[repo.PathWithNamespace] {
+				continue
+			}
+			seen[repo.PathWithNamespace] = true
+			allRepositories = append(allRepositories, repo)
 		}
-
-		options.Page = pagination.NextPage
 	}
 
 	return allRepositories, nil
 }
 
-// fetch single page of repo
-func (c *GitLabClient) fetchProjectPage(ctx context.Context, options GitLabAPIOptions) ([]GitLabProject, GitLabPaginationInfo, error) {
-	apiURL, err := c.buildAPIURL(options)
+// craft git url without credentials
+func (c *GitLabClient) CloneURL(repo Repository) string {
+	return httpsCloneURL(c.baseURL, repo.PathWithNamespace)
+}
+
+// gitlab accepts any username together with a token
+func (c *GitLabClient) Credentials() (string, string) {
+	return "gitlab-token", c.token
+}
+
+// fetch all repos with pagination
+func (c *GitLabClient) fetchAllProjects(ctx context.Context, options GitLabAPIOptions) ([]Repository, error) {
+	gitlabProjects, pagination, err := c.fetchProjectPage(ctx, options)
 	if err != nil {
-		return nil, GitLabPaginationInfo{}, fmt.Errorf("building API URL: %w", err)
+		return nil, fmt.Errorf("fetching page %d: %w", options.Page, err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	logger.Print(fmt.Sprintf("Fetched page %d/%d (%d projects)",
+		pagination.CurrentPage, pagination.TotalPages, len(gitlabProjects)), nil)
+
+	// gitlab leaves out the totals above 10000 projects and limits offset pagination
+	if pagination.TotalPages == 0 && pagination.NextPage != 0 {
+		logger.Print("Page count unknown, switching to keyset pagination", nil)
+		return c.fetchProjectsKeyset(ctx, options)
+	}
+
+	remaining, err := fetchRemainingPages(ctx, options, pagination.TotalPages, c.fetchProjectPage)
 	if err != nil {
-		return nil, GitLabPaginationInfo{}, fmt.Errorf("creating request: %w", err)
+		return nil, err
+	}
+
+	// convert gitlab repositories to repo type
+	gitlabProjects = append(gitlabProjects, remaining...)
+	return convertGitLabProjects(gitlabProjects, options.IncludeArchived), nil
+}
+
+// fetch pages 2 to totalPages concurrently, keeping the page order
+func fetchRemainingPages(ctx context.Context, options GitLabAPIOptions, totalPages int, fetchPage gitlabPageFetcher) ([]GitLabProject, error) {
+	if totalPages <= 1 {
+		return nil, nil
 	}
 
-	req.Header.Set("PRIVATE-TOKEN", c.token)
-	req.Header.Set("Accept", "application/json")
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	logger.Print("Making API request to: "+apiURL, nil)
+	pages := make([][]GitLabProject, totalPages+1)
+	errs := make([]error, totalPages+1)
 
-	resp, err := c.httpClient.Do(req)
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, gitlabPageWorkers)
+
+	for page := 2; page <= totalPages; page++ {
+		wg.Add(1)
+		semaphore <- struct{}{}
+
+		go func(pageOptions GitLabAPIOptions) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+
+			gitlabProjects, _, err := fetchPage(ctx, pageOptions)
+			if err != nil {
+				// no point in fetching the other pages
+				errs[pageOptions.Page] = fmt.Errorf("fetching page %d: %w", pageOptions.Page, err)
+				cancel()
+				return
+			}
+
+			pages[pageOptions.Page] = gitlabProjects
+			logger.Print(fmt.Sprintf("Fetched page %d/%d (%d projects)",
+				pageOptions.Page, totalPages, len(gitlabProjects)), nil)
+		}(withPage(options, page))
+	}
+	wg.Wait()
+
+	// report the real failure, not the pages cancelled because of it
+	var cancelErr error
+	for _, pageErr := range errs {
+		if pageErr == nil {
+			continue
+		}
+		if !errors.Is(pageErr, context.Canceled) {
+			return nil, pageErr
+		}
+		cancelErr = pageErr
+	}
+	if cancelErr != nil {
+		return nil, cancelErr
+	}
+
+	var gitlabProjects []GitLabProject
+	for _, page := range pages {
+		gitlabProjects = append(gitlabProjects, page...)
+	}
+	return gitlabProjects, nil
+}
+
+// copy of options for another page
+func withPage(options GitLabAPIOptions, page int) GitLabAPIOptions {
+	options.Page = page
+	return options
+}
+
+// walk all projects following the link header, used when the page count is unknown
+func (c *GitLabClient) fetchProjectsKeyset(ctx context.Context, options GitLabAPIOptions) ([]Repository, error) {
+	options.Keyset = true
+	apiURL, err := c.buildAPIURL(options)
 	if err != nil {
-		return nil, GitLabPaginationInfo{}, fmt.Errorf("making request: %w", err)
+		return nil, fmt.Errorf("building API URL: %w", err)
 	}
-	defer func() {
-		if closeErr := resp.Body.Close(); closeErr != nil {
-			logger.Print("WARNING: failed to close response body: "+closeErr.Error(), nil)
+
+	var allRepositories []Repository
+	for page := 1; apiURL != ""; page++ {
+		var gitlabProjects []GitLabProject
+		headers, err := c.getJSON(ctx, apiURL, &gitlabProjects)
+		if err != nil {
+			return nil, fmt.Errorf("fetching keyset page %d: %w", page, err)
 		}
-	}()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, GitLabPaginationInfo{}, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, resp.Status)
+		allRepositories = append(allRepositories, convertGitLabProjects(gitlabProjects, options.IncludeArchived)...)
+		logger.Print(fmt.Sprintf("Fetched keyset page %d (%d projects)", page, len(gitlabProjects)), nil)
+
+		apiURL = parseLinkHeader(headers.Get("Link"))["next"]
+	}
+
+	return allRepositories, nil
+}
+
+// fetch single page of repo
+func (c *GitLabClient) fetchProjectPage(ctx context.Context, options GitLabAPIOptions) ([]GitLabProject, GitLabPaginationInfo, error) {
+	apiURL, err := c.buildAPIURL(options)
+	if err != nil {
+		return nil, GitLabPaginationInfo{}, fmt.Errorf("building API URL: %w", err)
 	}
 
 	var gitlabProjects []GitLabProject
-	if err := json.NewDecoder(resp.Body).Decode(&gitlabProjects); err != nil {
-		return nil, GitLabPaginationInfo{}, fmt.Errorf("decoding JSON response: %w", err)
+	headers, err := c.getJSON(ctx, apiURL, &gitlabProjects)
+	if err != nil {
+		return nil, GitLabPaginationInfo{}, err
 	}
 
 	// check for more pages
-	pagination := parsePaginationHeaders(resp.Header)
+	pagination := parsePaginationHeaders(headers)
 
 	return gitlabProjects, pagination, nil
 }
@@ -177,10 +295,18 @@ func (c *GitLabClient) buildAPIURL(options GitLabAPIOptions) (string, error) {
 		query.Set("membership", "true")
 	}
 
-	query.Set("order_by", options.OrderBy)
-	query.Set("sort", options.Sort)
-	query.Set("per_page", strconv.Itoa(options.PerPage))
-	query.Set("page", strconv.Itoa(options.Page))
+	// keyset pagination only supports ordering by id
+	if options.Keyset {
+		query.Set("pagination", "keyset")
+		query.Set("order_by", "id")
+		query.Set("sort", "asc")
+		query.Set("per_page", strconv.Itoa(options.PerPage))
+	} else {
+		query.Set("order_by", options.OrderBy)
+		query.Set("sort", options.Sort)
+		query.Set("per_page", strconv.Itoa(options.PerPage))
+		query.Set("page", strconv.Itoa(options.Page))
+	}
 
 	if options.MinAccessLevel > 0 {
 		query.Set("min_access_level", strconv.Itoa(options.MinAccessLevel))
@@ -240,9 +366,15 @@ func convertGitLabProjects(gitlabProjects []GitLabProject, includeArchived strin
 			continue
 		}
 
+		// unparsable timestamps leave the repository to be pulled
+		lastActivity, _ := time.Parse(time.RFC3339, project.LastActivityAt)
+
 		repositories = append(repositories, Repository{
+			ID:                project.ID,
 			Name:              project.Name,
 			PathWithNamespace: project.PathWithNamespace,
+			SSHURL:            project.SSHURLToRepo,
+			LastActivityAt:    lastActivity,
 		})
 	}
 
@@ -251,63 +383,45 @@ func convertGitLabProjects(gitlabProjects []GitLabProject, includeArchived strin
 
 // connection validation
 func (c *GitLabClient) ValidateConnection(ctx context.Context) error {
-	apiURL := fmt.Sprintf("https://%s/api/v4/user", c.baseURL)
+	return c.validate(ctx, fmt.Sprintf("https://%s/api/v4/user", c.baseURL))
+}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
-	if err != nil {
-		return fmt.Errorf("creating validation request: %w", err)
+// fetch projects group
+func (c *GitLabClient) GetProjectsByGroup(ctx context.Context, groupID string, options GitLabAPIOptions) ([]Repository, error) {
+	fetchPage := func(ctx context.Context, options GitLabAPIOptions) ([]GitLabProject, GitLabPaginationInfo, error) {
+		return c.fetchGroupProjectPage(ctx, groupID, options)
 	}
 
-	req.Header.Set("PRIVATE-TOKEN", c.token)
-	req.Header.Set("Accept", "application/json")
-
-	resp, err := c.httpClient.Do(req)
+	gitlabProjects, pagination, err := fetchPage(ctx, options)
 	if err != nil {
-		return fmt.Errorf("making validation request: %w", err)
-	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			logger.Print("failed to close response body: %v", err)
-		}
-	}()
-
-	if resp.StatusCode == http.StatusUnauthorized {
-		return fmt.Errorf("invalid or expired token")
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("API validation failed with status %d", resp.StatusCode)
+		return nil, fmt.Errorf("fetching group page %d: %w", options.Page, err)
 	}
 
-	return nil
-}
+	logger.Print(fmt.Sprintf("Fetched group page %d/%d (%d projects)",
+		pagination.CurrentPage, pagination.TotalPages, len(gitlabProjects)), nil)
 
-// fetch projects group
-func (c *GitLabClient) GetProjectsByGroup(ctx context.Context, groupID string, options GitLabAPIOptions) ([]Repository, error) {
-	var allRepositories []Repository
+	// without a page count the group endpoint can only be walked page by page
+	for pagination.TotalPages == 0 && pagination.NextPage != 0 {
+		options.Page = pagination.NextPage
 
-	for {
-		gitlabProjects, pagination, err := c.fetchGroupProjectPage(ctx, groupID, options)
+		var pageProjects []GitLabProject
+		pageProjects, pagination, err = fetchPage(ctx, options)
 		if err != nil {
 			return nil, fmt.Errorf("fetching group page %d: %w", options.Page, err)
 		}
+		gitlabProjects = append(gitlabProjects, pageProjects...)
 
-		// Convert GitLab projects to our Repository type
-		repositories := convertGitLabProjects(gitlabProjects, options.IncludeArchived)
-		allRepositories = append(allRepositories, repositories...)
-
-		logger.Print(fmt.Sprintf("Fetched group page %d/%d (%d projects)",
-			pagination.CurrentPage, pagination.TotalPages, len(gitlabProjects)), nil)
-
-		// Check if we have more pages
-		if pagination.NextPage == 0 || pagination.CurrentPage >= pagination.TotalPages {
-			break
-		}
+		logger.Print(fmt.Sprintf("Fetched group page %d (%d projects)", options.Page, len(pageProjects)), nil)
+	}
 
-		options.Page = pagination.NextPage
+	remaining, err := fetchRemainingPages(ctx, options, pagination.TotalPages, fetchPage)
+	if err != nil {
+		return nil, fmt.Errorf("fetching group pages: %w", err)
 	}
 
-	return allRepositories, nil
+	// Convert GitLab projects to our Repository type
+	gitlabProjects = append(gitlabProjects, remaining...)
+	return convertGitLabProjects(gitlabProjects, options.IncludeArchived), nil
 }
 
 // fetch project page
@@ -317,43 +431,20 @@ func (c *GitLabClient) fetchGroupProjectPage(ctx context.Context, groupID string
 		return nil, GitLabPaginationInfo{}, fmt.Errorf("building group API URL: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
-	if err != nil {
-		return nil, GitLabPaginationInfo{}, fmt.Errorf("creating request: %w", err)
-	}
-
-	req.Header.Set("PRIVATE-TOKEN", c.token)
-	req.Header.Set("Accept", "application/json")
-
-	logger.Print("Making group API request to: "+apiURL, nil)
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, GitLabPaginationInfo{}, fmt.Errorf("making request: %w", err)
-	}
-	defer func() {
-		if closeErr := resp.Body.Close(); closeErr != nil {
-			logger.Print("WARNING: failed to close response body: "+closeErr.Error(), nil)
-		}
-	}()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, GitLabPaginationInfo{}, fmt.Errorf("group API request failed with status %d: %s", resp.StatusCode, resp.Status)
-	}
-
 	var gitlabProjects []GitLabProject
-	if err := json.NewDecoder(resp.Body).Decode(&gitlabProjects); err != nil {
-		return nil, GitLabPaginationInfo{}, fmt.Errorf("decoding JSON response: %w", err)
+	headers, err := c.getJSON(ctx, apiURL, &gitlabProjects)
+	if err != nil {
+		return nil, GitLabPaginationInfo{}, err
 	}
 
-	pagination := parsePaginationHeaders(resp.Header)
+	pagination := parsePaginationHeaders(headers)
 
 	return gitlabProjects, pagination, nil
 }
 
 // build api url
 func (c *GitLabClient) buildGroupAPIURL(groupID string, options GitLabAPIOptions) (string, error) {
-	baseURL := fmt.Sprintf("https://%s/api/v4/groups/%s/projects", c.baseURL, groupID)
+	baseURL := fmt.Sprintf("https://%s/api/v4/groups/%s/projects", c.baseURL, url.PathEscape(groupID))
 
 	u, err := url.Parse(baseURL)
 	if err != nil {
@@ -363,6 +454,13 @@ func (c *GitLabClient) buildGroupAPIURL(groupID string, options GitLabAPIOptions
 	query := u.Query()
 	query.Set("per_page", strconv.Itoa(options.PerPage))
 	query.Set("page", strconv.Itoa(options.Page))
+	query.Set("include_subgroups", strconv.FormatBool(options.IncludeSubgroup))
+	query.Set("with_shared", strconv.FormatBool(options.WithShared))
+
+	if options.OrderBy != "" {
+		query.Set("order_by", options.OrderBy)
+		query.Set("sort", options.Sort)
+	}
 
 	if options.MinAccessLevel > 0 {
 		query.Set("min_access_level", strconv.Itoa(options.MinAccessLevel))
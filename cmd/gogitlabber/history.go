@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// show the last runs recorded in the state files of the configured hosts
+func printHistory(configs []*Config, count int) error {
+	var runs []RunRecord
+	loaded := map[string]bool{}
+
+	for _, conf := range configs {
+		// hosts can share a destination and with it a state file
+		if loaded[conf.Destination] {
+			continue
+		}
+		loaded[conf.Destination] = true
+
+		state, err := loadState(conf.Destination)
+		if err != nil {
+			return fmt.Errorf("%s: %w", conf.Name, err)
+		}
+
+		for _, record := range state.Runs {
+			if configuredHost(configs, conf.Destination, record.Host) {
+				runs = append(runs, record)
+			}
+		}
+	}
+
+	if len(runs) == 0 {
+		fmt.Println("No runs recorded yet")
+		return nil
+	}
+
+	// newest first
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].StartedAt.After(runs[j].StartedAt)
+	})
+	if len(runs) > count {
+		runs = runs[:count]
+	}
+
+	for _, record := range runs {
+		printRunRecord(record)
+	}
+
+	return nil
+}
+
+// check if a host recorded in a state file belongs to the loaded config
+func configuredHost(configs []*Config, destination, name string) bool {
+	for _, conf := range configs {
+		if conf.Destination == destination && conf.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// print a single run with the repositories it changed
+func printRunRecord(record RunRecord) {
+	fmt.Printf(
+		"%s %s (%s): cloned %v, pulled %v, fetched %v, unchanged %v, errors %v\n",
+		record.StartedAt.Local().Format(time.DateTime),
+		record.Host,
+		record.FinishedAt.Sub(record.StartedAt).Round(time.Second),
+		record.Cloned,
+		record.Pulled,
+		record.Fetched,
+		record.Unchanged,
+		record.Errors,
+	)
+
+	for _, change := range record.Changes {
+		switch {
+		case change.From != "":
+			fmt.Printf(" %-7s %s from %s\n", change.Operation, change.Path, change.From)
+		case change.Error != "":
+			fmt.Printf(" %-7s %s: %s\n", change.Operation, change.Path, change.Error)
+		case change.OldHead != "":
+			fmt.Printf(" %-7s %s %s..%s\n", change.Operation, change.Path, shortHash(change.OldHead), shortHash(change.NewHead))
+		default:
+			fmt.Printf(" %-7s %s %s\n", change.Operation, change.Path, shortHash(change.NewHead))
+		}
+	}
+	fmt.Println("")
+}
+
+// abbreviate commit hash like git does
+func shortHash(hash string) string {
+	if len(hash) > 7 {
+		return hash[:7]
+	}
+	return hash
+}
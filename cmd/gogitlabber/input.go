@@ -4,8 +4,11 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"time"
 
 	"github.com/scornet256/go-logger"
 	"gopkg.in/yaml.v3"
@@ -13,28 +16,99 @@ import (
 
 // config struct for config
 type Config struct {
-	Concurrency     int    `yaml:"concurrency"`
-	Debug           bool   `yaml:"debug"`
-	Destination     string `yaml:"destination"`
-	GitBackend      string `yaml:"git_backend"`
-	GitHost         string `yaml:"git_host"`
-	GitToken        string `yaml:"git_token"`
-	GitUserMail     string `yaml:"git_user_mail"`
-	GitUserName     string `yaml:"git_user_name"`
-	IncludeArchived string `yaml:"include_archived"`
+	APIBase              string          `yaml:"api_base"`
+	CloneDepth           int             `yaml:"clone_depth"`
+	CloneFilter          string          `yaml:"clone_filter"`
+	CloneOverrides       []CloneOverride `yaml:"clone_overrides"`
+	CloneProtocol        string          `yaml:"clone_protocol"`
+	Command              string          `yaml:"-"`
+	Concurrency          int             `yaml:"concurrency"`
+	Debug                bool            `yaml:"debug"`
+	Destination          string          `yaml:"destination"`
+	DryRun               bool            `yaml:"-"`
+	Exclude              []string        `yaml:"exclude"`
+	FullSync             bool            `yaml:"-"`
+	GitBackend           string          `yaml:"git_backend"`
+	GiteaAdmin           bool            `yaml:"gitea_admin"`
+	GiteaOrgs            []string        `yaml:"gitea_orgs"`
+	GiteaOwners          []string        `yaml:"gitea_owners"`
+	GiteaTopics          []string        `yaml:"gitea_topics"`
+	GitHost              string          `yaml:"git_host"`
+	GitLabGroups         []string        `yaml:"gitlab_groups"`
+	GitLabWithShared     bool            `yaml:"gitlab_with_shared"`
+	GitToken             string          `yaml:"git_token"`
+	GitTokenCommand      string          `yaml:"git_token_command"`
+	GitTokenEnv          string          `yaml:"git_token_env"`
+	GitTokenFile         string          `yaml:"git_token_file"`
+	GitUserMail          string          `yaml:"git_user_mail"`
+	GitUserName          string          `yaml:"git_user_name"`
+	HistoryRuns          int             `yaml:"-"`
+	Hosts                []yaml.Node     `yaml:"hosts"`
+	Include              []string        `yaml:"include"`
+	IncludeArchived      string          `yaml:"include_archived"`
+	MaxRequestsPerSecond float64         `yaml:"max_requests_per_second"`
+	MigrateRemotes       bool            `yaml:"-"`
+	Mode                 string          `yaml:"mode"`
+	Name                 string          `yaml:"name"`
+	PruneAction          string          `yaml:"-"`
+	Report               string          `yaml:"-"`
+	ReportFormat         string          `yaml:"-"`
+	RetryAttempts        int             `yaml:"retry_attempts"`
+	RetryBackoff         time.Duration   `yaml:"retry_backoff"`
+	RetryJitter          float64         `yaml:"retry_jitter"`
+	RetryMaxBackoff      time.Duration   `yaml:"retry_max_backoff"`
+	SSHKeyFile           string          `yaml:"ssh_key_file"`
+	SSHKeyPassword       string          `yaml:"ssh_key_passphrase"`
+	SSHKnownHosts        string          `yaml:"ssh_known_hosts"`
+	SSHPort              int             `yaml:"ssh_port"`
+	SingleBranch         bool            `yaml:"single_branch"`
+	UpdateStrategy       string          `yaml:"update_strategy"`
+
+	// where the token was read from, never the token itself
+	tokenSource string
 }
 
 // setdefaults sets default values for the configuration
 func (conf *Config) setDefaults() {
+	conf.APIBase = ""
+	conf.CloneDepth = 0
+	conf.CloneFilter = ""
+	conf.CloneOverrides = nil
+	conf.CloneProtocol = "https"
 	conf.Concurrency = 15
 	conf.Debug = false
 	conf.Destination = "$HOME/Documents"
+	conf.Exclude = nil
 	conf.GitBackend = ""
-	conf.GitHost = "gitlab.com"
+	conf.GiteaAdmin = false
+	conf.GiteaOrgs = nil
+	conf.GiteaOwners = nil
+	conf.GiteaTopics = nil
+	conf.GitHost = ""
+	conf.GitLabGroups = nil
+	conf.GitLabWithShared = false
 	conf.GitToken = ""
+	conf.GitTokenCommand = ""
+	conf.GitTokenEnv = ""
+	conf.GitTokenFile = ""
 	conf.GitUserMail = ""
 	conf.GitUserName = ""
+	conf.Hosts = nil
+	conf.Include = nil
 	conf.IncludeArchived = "excluded"
+	conf.MaxRequestsPerSecond = 0
+	conf.Mode = "checkout"
+	conf.Name = ""
+	conf.RetryAttempts = 3
+	conf.RetryBackoff = 2 * time.Second
+	conf.RetryJitter = 0.2
+	conf.RetryMaxBackoff = 30 * time.Second
+	conf.SSHKeyFile = ""
+	conf.SSHKeyPassword = ""
+	conf.SSHKnownHosts = ""
+	conf.SSHPort = 0
+	conf.SingleBranch = false
+	conf.UpdateStrategy = "pull"
 }
 
 // expand variable paths
@@ -85,11 +159,89 @@ func loadConfig(configPath string) (*Config, error) {
 	return cfg, nil
 }
 
+// split config into one config per host, hosts inherit top-level settings
+func (conf *Config) hostConfigs() ([]*Config, error) {
+	if len(conf.Hosts) == 0 {
+		return []*Config{conf}, nil
+	}
+
+	var configs []*Config
+	for i := range conf.Hosts {
+		hostConf := *conf
+		hostConf.Hosts = nil
+		hostConf.Name = ""
+
+		// a host on another backend must not inherit the host of the top level
+		if setsOption(&conf.Hosts[i], "git_backend") && !setsOption(&conf.Hosts[i], "git_host") {
+			hostConf.GitHost = ""
+		}
+
+		// a host with its own token source must not inherit another one
+		if hasTokenSource(&conf.Hosts[i]) {
+			hostConf.GitToken = ""
+			hostConf.GitTokenCommand = ""
+			hostConf.GitTokenEnv = ""
+			hostConf.GitTokenFile = ""
+		}
+
+		if err := conf.Hosts[i].Decode(&hostConf); err != nil {
+			return nil, fmt.Errorf("failed to parse hosts entry %d: %w", i+1, err)
+		}
+		configs = append(configs, &hostConf)
+	}
+
+	return configs, nil
+}
+
+// check if a hosts entry sets any of the token options
+func hasTokenSource(node *yaml.Node) bool {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if strings.HasPrefix(node.Content[i].Value, "git_token") {
+			return true
+		}
+	}
+	return false
+}
+
+// check if a hosts entry sets an option
+func setsOption(node *yaml.Node, key string) bool {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return true
+		}
+	}
+	return false
+}
+
+// git_host used when none is set, only backends with a public instance have one
+func defaultGitHost(backend string) string {
+	switch backend {
+	case "gitlab":
+		return "gitlab.com"
+	case "github":
+		return "github.com"
+	default:
+		return ""
+	}
+}
+
 // validateConfig validates the configuration values
 func (conf *Config) validateConfig() error {
 	// validate required parameters
+	if conf.GitBackend == "" {
+		return fmt.Errorf("git_backend is required (%s)", supportedBackends())
+	}
+	if _, ok := providers[conf.GitBackend]; !ok {
+		return fmt.Errorf("unsupported git_backend: %s (supported: %s)", conf.GitBackend, supportedBackends())
+	}
+	if conf.GitHost == "" {
+		return fmt.Errorf("git_host is required for git_backend %s", conf.GitBackend)
+	}
+	if err := conf.resolveToken(); err != nil {
+		return err
+	}
 	if conf.GitToken == "" {
-		return fmt.Errorf("git_token is required")
+		return fmt.Errorf("git_token is required (or one of git_token_file|git_token_env|git_token_command)")
 	}
 
 	// validate archived option
@@ -99,6 +251,100 @@ func (conf *Config) validateConfig() error {
 		return fmt.Errorf("invalid include_archived option: %s (must be any|excluded|exclusive)", conf.IncludeArchived)
 	}
 
+	// validate backend specific options
+	if len(conf.GitLabGroups) > 0 && conf.GitBackend != "gitlab" {
+		return fmt.Errorf("gitlab_groups is only supported by the gitlab backend")
+	}
+
+	giteaOptions := conf.GiteaAdmin || len(conf.GiteaOrgs) > 0 || len(conf.GiteaOwners) > 0 || len(conf.GiteaTopics) > 0
+	switch conf.GitBackend {
+	case "gitea", "forgejo", "gogs":
+	default:
+		if giteaOptions {
+			return fmt.Errorf("gitea_* options are only supported by the gitea|forgejo|gogs backends")
+		}
+	}
+	if conf.GiteaAdmin && len(conf.GiteaOrgs) > 0 {
+		return fmt.Errorf("gitea_admin and gitea_orgs can not be combined")
+	}
+
+	// validate filter patterns
+	if _, err := NewRepositoryFilter(conf.Include, conf.Exclude); err != nil {
+		return err
+	}
+
+	// validate clone protocol
+	switch conf.CloneProtocol {
+	case "https", "ssh":
+	default:
+		return fmt.Errorf("invalid clone_protocol option: %s (must be https|ssh)", conf.CloneProtocol)
+	}
+
+	// validate mode, mirrors always hold the complete history
+	switch conf.Mode {
+	case "checkout":
+	case "mirror":
+		if conf.CloneDepth > 0 || conf.SingleBranch || conf.CloneFilter != "" || len(conf.CloneOverrides) > 0 {
+			return fmt.Errorf("clone_depth, single_branch, clone_filter and clone_overrides can't be used with mode: mirror")
+		}
+	default:
+		return fmt.Errorf("invalid mode option: %s (must be checkout|mirror)", conf.Mode)
+	}
+
+	// validate update strategy
+	switch conf.UpdateStrategy {
+	case "pull", "fetch":
+	default:
+		return fmt.Errorf("invalid update_strategy option: %s (must be pull|fetch)", conf.UpdateStrategy)
+	}
+
+	// validate clone settings, overrides are checked on top of the defaults
+	if err := conf.cloneSettings().validate(); err != nil {
+		return err
+	}
+	rules, err := newCloneRules(conf.CloneOverrides)
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		if err := resolveCloneSettings(conf.cloneSettings(), []cloneRule{rule}, rule.override.Match).validate(); err != nil {
+			return fmt.Errorf("clone_overrides %q: %w", rule.override.Match, err)
+		}
+	}
+
+	// the git command checking out partial clones can't unlock encrypted keys
+	if conf.CloneProtocol == "ssh" && conf.SSHKeyPassword != "" && conf.usesCloneFilter() {
+		return fmt.Errorf("clone_filter can't be used with ssh_key_passphrase, use an unencrypted key or ssh-agent")
+	}
+
+	// validate ssh port
+	if conf.SSHPort < 0 || conf.SSHPort > 65535 {
+		return fmt.Errorf("ssh_port must be between 1 and 65535")
+	}
+
+	// validate report format
+	switch conf.ReportFormat {
+	case "", "json", "ndjson":
+	default:
+		return fmt.Errorf("invalid report format: %s (must be json|ndjson)", conf.ReportFormat)
+	}
+
+	// validate retry policy
+	if conf.RetryAttempts < 1 {
+		return fmt.Errorf("retry_attempts must be greater than 0")
+	}
+	if conf.RetryBackoff < 0 || conf.RetryMaxBackoff < 0 {
+		return fmt.Errorf("retry_backoff and retry_max_backoff must not be negative")
+	}
+	if conf.RetryJitter < 0 || conf.RetryJitter > 1 {
+		return fmt.Errorf("retry_jitter must be between 0 and 1")
+	}
+
+	// validate rate limit
+	if conf.MaxRequestsPerSecond < 0 {
+		return fmt.Errorf("max_requests_per_second must not be negative")
+	}
+
 	// validate concurrency
 	if conf.Concurrency < 1 {
 		return fmt.Errorf("concurrency must be greater than 0")
@@ -107,10 +353,112 @@ func (conf *Config) validateConfig() error {
 	return nil
 }
 
+// clone settings used unless an override matches
+func (conf *Config) cloneSettings() CloneSettings {
+	return CloneSettings{
+		Depth:        conf.CloneDepth,
+		SingleBranch: conf.SingleBranch,
+		Filter:       conf.CloneFilter,
+	}
+}
+
+// check if any repository is cloned with a filter
+func (conf *Config) usesCloneFilter() bool {
+	if conf.CloneFilter != "" {
+		return true
+	}
+	for _, override := range conf.CloneOverrides {
+		if override.CloneFilter != nil && *override.CloneFilter != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve token from the configured source
+func (conf *Config) resolveToken() error {
+	sources := 0
+	for _, source := range []string{conf.GitToken, conf.GitTokenFile, conf.GitTokenEnv, conf.GitTokenCommand} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return fmt.Errorf("only one of git_token|git_token_file|git_token_env|git_token_command may be set")
+	}
+
+	switch {
+	case conf.GitTokenFile != "":
+		data, err := os.ReadFile(expandPath(conf.GitTokenFile))
+		if err != nil {
+			return fmt.Errorf("reading git_token_file: %w", err)
+		}
+		conf.GitToken = strings.TrimSpace(string(data))
+		conf.tokenSource = "file " + expandPath(conf.GitTokenFile)
+
+	case conf.GitTokenEnv != "":
+		token, ok := os.LookupEnv(conf.GitTokenEnv)
+		if !ok {
+			return fmt.Errorf("git_token_env: environment variable %s is not set", conf.GitTokenEnv)
+		}
+		conf.GitToken = strings.TrimSpace(token)
+		conf.tokenSource = "environment variable " + conf.GitTokenEnv
+
+	case conf.GitTokenCommand != "":
+		token, err := runTokenCommand(conf.GitTokenCommand)
+		if err != nil {
+			return fmt.Errorf("running git_token_command: %w", err)
+		}
+		conf.GitToken = token
+		conf.tokenSource = "command"
+
+	case conf.GitToken != "":
+		conf.tokenSource = "config file"
+	}
+
+	return nil
+}
+
+// run token command and use its first line of output
+func runTokenCommand(command string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Stderr = os.Stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+
+	// password managers like pass keep the secret on the first line
+	token, _, _ := strings.Cut(string(output), "\n")
+	return strings.TrimSpace(token), nil
+}
+
 // process config after loading
 func (conf *Config) processConfig() {
+	// gitlab and github default to their public instance
+	if conf.GitHost == "" {
+		conf.GitHost = defaultGitHost(conf.GitBackend)
+	}
+
+	// name host after its address unless set
+	if conf.Name == "" {
+		conf.Name = conf.GitHost
+	}
+
 	// expand path variables
 	conf.Destination = expandPath(conf.Destination)
+	if conf.SSHKeyFile != "" {
+		conf.SSHKeyFile = expandPath(conf.SSHKeyFile)
+	}
+	if conf.SSHKnownHosts != "" {
+		conf.SSHKnownHosts = expandPath(conf.SSHKnownHosts)
+	}
 
 	// add trailing slash if not provided
 	if !strings.HasSuffix(conf.Destination, "/") {
@@ -121,17 +469,54 @@ func (conf *Config) processConfig() {
 // log active config
 func (conf *Config) logConfig(configPath string) {
 	logger.Print("Configuration: Using config file: "+configPath, nil)
+	logger.Print("Configuration: Using host profile: "+conf.Name, nil)
 	logger.Print("Configuration: Using host: "+conf.GitHost, nil)
+	logger.Print("Configuration: Using token from: "+conf.tokenSource, nil)
+	if conf.APIBase != "" {
+		logger.Print("Configuration: Using API base: "+conf.APIBase, nil)
+	}
 	logger.Print("Configuration: Using destination: "+conf.Destination, nil)
 	logger.Print("Configuration: Using concurrency: "+fmt.Sprintf("%d", conf.Concurrency), nil)
 	logger.Print("Configuration: Using archived option: "+conf.IncludeArchived, nil)
+	logger.Print("Configuration: Using clone protocol: "+conf.CloneProtocol, nil)
+	logger.Print("Configuration: Using mode: "+conf.Mode, nil)
+	logger.Print("Configuration: Using update strategy: "+conf.UpdateStrategy, nil)
+	if conf.CloneDepth > 0 || conf.SingleBranch || conf.CloneFilter != "" || len(conf.CloneOverrides) > 0 {
+		logger.Print(fmt.Sprintf("Configuration: Using clone depth %d, single branch %t, filter %q and %d overrides",
+			conf.CloneDepth, conf.SingleBranch, conf.CloneFilter, len(conf.CloneOverrides)), nil)
+	}
+	if conf.MaxRequestsPerSecond > 0 {
+		logger.Print(fmt.Sprintf("Configuration: Using at most %g API requests per second", conf.MaxRequestsPerSecond), nil)
+	}
+	logger.Print(fmt.Sprintf("Configuration: Using retry policy: %d attempts, %s backoff", conf.RetryAttempts, conf.RetryBackoff), nil)
+	if len(conf.GitLabGroups) > 0 {
+		logger.Print("Configuration: Using gitlab groups: "+strings.Join(conf.GitLabGroups, ", "), nil)
+	}
+	if conf.GiteaAdmin {
+		logger.Print("Configuration: Using gitea admin mode", nil)
+	}
+	if len(conf.GiteaOrgs) > 0 {
+		logger.Print("Configuration: Using gitea organizations: "+strings.Join(conf.GiteaOrgs, ", "), nil)
+	}
+	if len(conf.Include) > 0 {
+		logger.Print("Configuration: Using include patterns: "+strings.Join(conf.Include, ", "), nil)
+	}
+	if len(conf.Exclude) > 0 {
+		logger.Print("Configuration: Using exclude patterns: "+strings.Join(conf.Exclude, ", "), nil)
+	}
+	if conf.CloneProtocol == "ssh" && conf.SSHKeyFile != "" {
+		logger.Print("Configuration: Using ssh key file: "+conf.SSHKeyFile, nil)
+	}
+	if conf.FullSync {
+		logger.Print("Configuration: Full sync, ignoring last sync time", nil)
+	}
 	if conf.Debug {
 		logger.Print("Configuration: Debug mode enabled", nil)
 	}
 }
 
 // manage arguments
-func manageArguments() *Config {
+func manageArguments() []*Config {
 
 	// default config path
 	defaultConfigPath := "./$HOME./.config/gogitlabber.yaml"
@@ -144,6 +529,11 @@ func manageArguments() *Config {
 
 	versionFlag := flag.Bool("version", false, "Print the version and exit")
 	debugFlag := flag.Bool("debug", false, "Enable debug mode")
+	fullFlag := flag.Bool("full", false, "Pull every repository, even without activity since the last sync")
+	dryRunFlag := flag.Bool("dry-run", false, "Show what would be cloned or pulled without touching disk")
+	reportFlag := flag.String("report", "", "Write a JSON report to this file, - for stdout")
+	reportFormatFlag := flag.String("report-format", "json", "Report format (json|ndjson)")
+	migrateFlag := flag.Bool("migrate-remotes", false, "Remove tokens from remote URLs in destination and exit")
 
 	flag.Parse()
 
@@ -152,6 +542,35 @@ func manageArguments() *Config {
 		os.Exit(0)
 	}
 
+	// commands follow the flags
+	command := flag.Arg(0)
+	historyRuns := 0
+	pruneAction := ""
+	switch command {
+	case "":
+	case "history":
+		historyFlags := flag.NewFlagSet("history", flag.ExitOnError)
+		historyRunsFlag := historyFlags.Int("n", 10, "Number of runs to show")
+		_ = historyFlags.Parse(flag.Args()[1:])
+		if *historyRunsFlag < 1 {
+			logger.Fatal("Configuration error: history -n must be greater than 0", nil)
+		}
+		historyRuns = *historyRunsFlag
+	case "prune":
+		pruneFlags := flag.NewFlagSet("prune", flag.ExitOnError)
+		pruneActionFlag := pruneFlags.String("action", "report", "What to do with orphaned repositories (report|move|delete)")
+		_ = pruneFlags.Parse(flag.Args()[1:])
+		switch *pruneActionFlag {
+		case "report", "move", "delete":
+		default:
+			logger.Fatal("Configuration error: invalid prune action: "+*pruneActionFlag+" (must be report|move|delete)", nil)
+		}
+		pruneAction = *pruneActionFlag
+	default:
+		flag.Usage()
+		logger.Fatal("Configuration error: unknown command: "+command, nil)
+	}
+
 	configPath := *configFileFlag
 
 	// Load configuration from YAML file
@@ -165,18 +584,43 @@ func manageArguments() *Config {
 	if *debugFlag {
 		cfg.Debug = true
 	}
-
-	// Process configuration
-	cfg.processConfig()
-
-	// Validate configuration
-	if err := cfg.validateConfig(); err != nil {
+	cfg.DryRun = *dryRunFlag
+	cfg.FullSync = *fullFlag
+	cfg.Report = *reportFlag
+	cfg.ReportFormat = *reportFormatFlag
+	cfg.MigrateRemotes = *migrateFlag
+	cfg.Command = command
+	cfg.HistoryRuns = historyRuns
+	cfg.PruneAction = pruneAction
+
+	// split into host profiles
+	configs, err := cfg.hostConfigs()
+	if err != nil {
 		flag.Usage()
-		logger.Fatal("Configuration validation error: "+err.Error(), nil)
+		logger.Fatal("Configuration error: "+err.Error(), nil)
 	}
 
-	// Log configuration
-	cfg.logConfig(configPath)
+	names := map[string]bool{}
+	for _, hostCfg := range configs {
+		// Process configuration
+		hostCfg.processConfig()
+
+		// state and history are kept per host name
+		if names[hostCfg.Name] {
+			flag.Usage()
+			logger.Fatal("Configuration error: duplicate host name "+hostCfg.Name+", set a unique name for every host", nil)
+		}
+		names[hostCfg.Name] = true
+
+		// Validate configuration
+		if err := hostCfg.validateConfig(); err != nil {
+			flag.Usage()
+			logger.Fatal("Configuration validation error ("+hostCfg.Name+"): "+err.Error(), nil)
+		}
+
+		// Log configuration
+		hostCfg.logConfig(configPath)
+	}
 
-	return cfg
+	return configs
 }
@@ -1,7 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/scornet256/go-logger"
 )
@@ -12,8 +17,21 @@ var globalConfig *Config
 
 // repository data
 type Repository struct {
-	Name              string `json:"name"`
-	PathWithNamespace string `json:"path_with_namespace"`
+	ID                int       `json:"id,omitempty"`
+	Name              string    `json:"name"`
+	PathWithNamespace string    `json:"path_with_namespace"`
+	SSHURL            string    `json:"ssh_url"`
+	LastActivityAt    time.Time `json:"last_activity_at,omitzero"`
+}
+
+// result of syncing a single host
+type HostRun struct {
+	Name  string
+	Stats *GitStats
+	Err   error
+
+	// only used to keep the token out of reports
+	token string
 }
 
 func main() {
@@ -25,41 +43,204 @@ func main() {
 	logger.SetAppName("gogitlabber")
 
 	// manage all argument magic and load configuration
-	globalConfig = manageArguments()
+	configs := manageArguments()
+	globalConfig = configs[0]
 
 	// set debugging
 	logger.SetDebug(globalConfig.Debug)
 
-	// validate git backend is set
-	if globalConfig.GitBackend == "" {
-		logger.Fatal("Configuration error: git_backend is required (gitlab|gitea)", nil)
+	// clean up remotes written by older versions
+	if globalConfig.MigrateRemotes {
+		var migrated []string
+		for _, conf := range configs {
+			hostMigrated, err := migrateRemoteURLs(conf.Destination, conf.GitHost)
+			if err != nil {
+				logger.Fatal("Migrating remotes failed: "+err.Error(), nil)
+			}
+			migrated = append(migrated, hostMigrated...)
+		}
+		printMigrationSummary(migrated)
+		return
+	}
+
+	// show what earlier runs did
+	if globalConfig.Command == "history" {
+		if err := printHistory(configs, globalConfig.HistoryRuns); err != nil {
+			logger.Fatal("Reading history failed: "+err.Error(), nil)
+		}
+		return
 	}
 
+	// deal with repositories that are gone from the server
+	if globalConfig.Command == "prune" {
+		action := globalConfig.PruneAction
+		if globalConfig.DryRun {
+			action = "report"
+		}
+		orphans, err := pruneDestinations(context.Background(), configs, action)
+		if err != nil {
+			logger.Fatal("Pruning failed: "+err.Error(), nil)
+		}
+		printPruneSummary(orphans)
+		return
+	}
+
+	// stdout is reserved for the report
+	quiet := globalConfig.Report == "-"
+
 	// make initial progressbar
-	if !globalConfig.Debug {
+	if !globalConfig.Debug && !globalConfig.DryRun && !quiet {
 		progressBar()
 	}
 
-	// fetch repository information
-	var repositories []Repository
-	var err error
-	switch globalConfig.GitBackend {
-	case "gitea":
-		repositories, err = FetchRepositoriesGitea()
-		if err != nil {
-			logger.Fatal("Fetching repositories failed", err)
+	// cancel on ctrl-c, a second signal terminates immediately
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		signal.Stop(signals)
+		fmt.Fprintln(os.Stderr, "\nInterrupted, aborting running operations and cleaning up...")
+		cancel()
+	}()
+
+	// sync every configured host
+	startedAt := time.Now()
+	var runs []*HostRun
+	for _, conf := range configs {
+		if ctx.Err() != nil {
+			break
 		}
-	case "gitlab":
-		repositories, err = FetchRepositoriesGitLab()
-		if err != nil {
-			logger.Fatal("Fetching repositories failed", err)
+		globalConfig = conf
+		runs = append(runs, syncHost(ctx, conf))
+	}
+	cancelled := ctx.Err() != nil
+
+	// write machine readable report
+	if globalConfig.Report != "" && !globalConfig.DryRun {
+		report := buildReport(runs, startedAt)
+		if err := writeReport(report, globalConfig.Report, globalConfig.ReportFormat); err != nil {
+			logger.Fatal("Writing report failed: "+err.Error(), nil)
 		}
-	default:
-		logger.Fatal(fmt.Sprintf("Unsupported git backend: %s (supported: gitlab|gitea)", globalConfig.GitBackend), nil)
 	}
 
+	// nothing could be fetched at all
+	if !cancelled && countFailedHosts(runs) == len(runs) {
+		last := runs[len(runs)-1]
+		logger.Fatal("Fetching repositories failed: "+last.Name+": "+last.Err.Error(), nil)
+	}
+
+	// the plan has been printed per host
+	if globalConfig.DryRun {
+		if cancelled {
+			os.Exit(ExitCancelled)
+		}
+		return
+	}
+
+	if !quiet {
+		printRunSummary(runs)
+	}
+
+	if cancelled {
+		os.Exit(ExitCancelled)
+	}
+	os.Exit(exitCode(runs))
+}
+
+// sync all repositories of a single host
+func syncHost(ctx context.Context, conf *Config) *HostRun {
+	run := &HostRun{Name: conf.Name, Stats: &GitStats{}, token: conf.GitToken}
+
+	// set up git backend
+	provider, err := newProvider(conf)
+	if err != nil {
+		return run.fail(err)
+	}
+
+	// set up repository filter
+	filter, err := NewRepositoryFilter(conf.Include, conf.Exclude)
+	if err != nil {
+		return run.fail(err)
+	}
+
+	// remembered state powers incremental sync
+	state, err := loadState(conf.Destination)
+	if err != nil {
+		return run.fail(err)
+	}
+	hostState := state.host(conf.Name)
+	incremental := hostState
+	if conf.FullSync {
+		incremental = nil
+	}
+	syncStarted := time.Now()
+
+	// fetch repository information
+	repositories, err := FetchRepositories(ctx, provider, filter)
+	if counter, ok := provider.(retryCounter); ok {
+		run.Stats.apiRetryCount = counter.RetryCount()
+	}
+	if err != nil {
+		return run.fail(err)
+	}
+
+	// only show what would happen
+	if conf.DryRun {
+		printPlan(conf.Name, planRepositories(repositories, hostState, incremental))
+		return run
+	}
+
+	// set up git transport
+	remote, err := newGitRemote(provider, conf)
+	if err != nil {
+		return run.fail(err)
+	}
+
+	// follow renamed and transferred repositories
+	run.Stats.moves = moveRenamedRepositories(repositories, hostState)
+
 	// manage found repositories
-	stats := &GitStats{}
-	CheckoutRepositories(repositories, stats)
-	printDetailedSummary(stats)
+	CheckoutRepositories(ctx, remote, skipUnchanged(repositories, incremental, run.Stats), run.Stats)
+
+	// remember what happened for the next run
+	state.record(run, repositories, syncStarted)
+	if err := state.save(conf.Destination); err != nil {
+		logger.Print("WARNING: failed to save state: "+err.Error(), nil)
+	}
+
+	return run
+}
+
+// mark host as failed
+func (run *HostRun) fail(err error) *HostRun {
+	run.Err = err
+	logger.Print("ERROR syncing host "+run.Name+": "+err.Error(), nil)
+	return run
+}
+
+// most severe exit code over all hosts
+func exitCode(runs []*HostRun) int {
+	code := ExitOK
+	for _, run := range runs {
+		if run.Err != nil {
+			return ExitFatal
+		}
+		if hostCode := run.Stats.ExitCode(); hostCode > code {
+			code = hostCode
+		}
+	}
+	return code
+}
+
+// count hosts that could not be synced
+func countFailedHosts(runs []*HostRun) int {
+	failed := 0
+	for _, run := range runs {
+		if run.Err != nil {
+			failed++
+		}
+	}
+	return failed
 }
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/scornet256/go-logger"
+)
+
+// strip tokens from remotes written by older versions
+func migrateRemoteURLs(destination, host string) ([]string, error) {
+	repoPaths, err := findGitRepositories(destination)
+	if err != nil {
+		return nil, fmt.Errorf("scanning destination: %w", err)
+	}
+
+	var migrated []string
+	for _, repoPath := range repoPaths {
+		changed, err := migrateRemoteURL(repoPath, host)
+		if err != nil {
+			logger.Print("WARNING: failed to migrate remote of "+repoPath+": "+err.Error(), nil)
+			continue
+		}
+		if changed {
+			logger.Print("Removed credentials from remote of: "+repoPath, nil)
+			migrated = append(migrated, repoPath)
+		}
+	}
+
+	return migrated, nil
+}
+
+// strip tokens from the remotes of a single repository
+func migrateRemoteURL(repoPath, host string) (bool, error) {
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return false, fmt.Errorf("opening repository: %w", err)
+	}
+
+	cfg, err := repo.Config()
+	if err != nil {
+		return false, fmt.Errorf("getting config: %w", err)
+	}
+
+	changed := false
+	for _, remote := range cfg.Remotes {
+		for i, remoteURL := range remote.URLs {
+			if cleanURL, ok := stripURLCredentials(remoteURL, host); ok {
+				remote.URLs[i] = cleanURL
+				changed = true
+			}
+		}
+	}
+
+	if !changed {
+		return false, nil
+	}
+
+	if err := repo.SetConfig(cfg); err != nil {
+		return false, fmt.Errorf("setting config: %w", err)
+	}
+
+	return true, nil
+}
+
+// remove user and password from an https url on host
+func stripURLCredentials(rawURL, host string) (string, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL, false
+	}
+
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return rawURL, false
+	}
+
+	if u.Host != host && u.Hostname() != host {
+		return rawURL, false
+	}
+
+	if _, hasPassword := u.User.Password(); !hasPassword {
+		return rawURL, false
+	}
+
+	u.User = nil
+	return u.String(), true
+}
+
+// print migration result
+func printMigrationSummary(migrated []string) {
+	fmt.Println("")
+	fmt.Printf("Migrated remotes: %v\n", len(migrated))
+	for _, repoPath := range migrated {
+		fmt.Printf("• %s\n", repoPath)
+	}
+	fmt.Println()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/config"
+	"github.com/scornet256/go-logger"
+)
+
+// mirrors copy every ref of the server, including tags, notes and merge request refs
+const mirrorRefSpec = config.RefSpec("+refs/*:refs/*")
+
+// local path of a repository, mirrors are bare repositories in <path>.git
+func localPath(repoName string) string {
+	path := filepath.Join(globalConfig.Destination, repoName)
+	if globalConfig.Mode == "mirror" {
+		path += ".git"
+	}
+	return path
+}
+
+// clone new repository as bare mirror
+func cloneMirror(ctx context.Context, remote *gitRemote, repoName, repoDestination, gitURL string) GitOperationResult {
+	logger.Print("Mirroring repository: "+repoName, nil)
+
+	// ensure parent directory exists
+	if err := os.MkdirAll(filepath.Dir(repoDestination), 0755); err != nil {
+		return GitOperationResult{
+			RepoName:  repoName,
+			Operation: "error",
+			Error:     fmt.Errorf("creating parent directory: %w", err),
+		}
+	}
+
+	_, statErr := os.Stat(repoDestination)
+	existed := statErr == nil
+
+	_, err := git.PlainCloneContext(ctx, repoDestination, &git.CloneOptions{
+		URL:           gitURL,
+		ClientOptions: remote.clientOptions,
+		Mirror:        true,
+		Progress:      nil,
+	})
+	if err != nil {
+		// don't leave half populated directories behind
+		if !existed {
+			removePartialClone(repoDestination)
+		}
+		return GitOperationResult{
+			RepoName:  repoName,
+			Operation: "error",
+			Error:     fmt.Errorf("mirroring repository: %w", err),
+		}
+	}
+
+	return GitOperationResult{
+		RepoName:  repoName,
+		Operation: "cloned",
+	}
+}
+
+// update mirror, refs deleted on the server are deleted here too
+func fetchMirror(ctx context.Context, remote *gitRemote, repoName, repoDestination, gitURL string) GitOperationResult {
+	logger.Print("Fetching mirror: "+repoName, nil)
+
+	repo, err := git.PlainOpen(repoDestination)
+	if err != nil {
+		return GitOperationResult{
+			RepoName:  repoName,
+			Operation: "error",
+			Error:     fmt.Errorf("opening repository: %w", err),
+		}
+	}
+
+	// update remote URL so renamed repositories keep working
+	if err := updateRemoteURL(repoDestination, gitURL); err != nil {
+		logger.Print("WARNING: failed to update remote URL: "+err.Error(), nil)
+	}
+
+	err = repo.FetchContext(ctx, &git.FetchOptions{
+		RemoteName:    git.DefaultRemoteName,
+		RefSpecs:      []config.RefSpec{mirrorRefSpec},
+		ClientOptions: remote.clientOptions,
+		Force:         true,
+		Prune:         true,
+		Progress:      nil,
+	})
+	if err != nil {
+		if err == git.NoErrAlreadyUpToDate {
+			logger.Print("Mirror already up to date: "+repoName, nil)
+		} else {
+			return GitOperationResult{
+				RepoName:  repoName,
+				Operation: "error",
+				Error:     fmt.Errorf("fetching mirror: %w", err),
+				ErrorType: "other",
+			}
+		}
+	}
+
+	return GitOperationResult{
+		RepoName:  repoName,
+		Operation: "pulled",
+	}
+}
@@ -36,8 +36,8 @@ func progressBar() {
 
 // update progressbar
 func updateProgressBar(repoCount int) error {
-	if globalConfig.Debug {
-		return nil // Skip progress bar in debug mode
+	if globalConfig.Debug || bar == nil {
+		return nil // Skip progress bar in debug and dry-run mode
 	}
 	logger.Print("Resetting progress bar", nil)
 	if err := bar.Set(0); err != nil {
@@ -56,11 +56,27 @@ func printSummary(stats *GitStats) {
 		"Summary:\n"+
 			" Cloned repositories: %v\n"+
 			" Pulled repositories: %v\n"+
-			" Errors: %v\n\n",
+			" Errors: %v\n",
 		stats.clonedCount,
 		stats.pulledCount,
 		stats.errorCount,
 	)
+	if stats.fetchedCount > 0 {
+		fmt.Printf(" Fetched repositories: %v\n", stats.fetchedCount)
+	}
+	if len(stats.moves) > 0 {
+		fmt.Printf(" Moved repositories: %v\n", len(stats.moves))
+	}
+	if stats.unchangedCount > 0 {
+		fmt.Printf(" Unchanged repositories: %v\n", stats.unchangedCount)
+	}
+	if stats.cancelledCount > 0 {
+		fmt.Printf(" Cancelled repositories: %v\n", stats.cancelledCount)
+	}
+	if stats.gitRetryCount > 0 || stats.apiRetryCount > 0 {
+		fmt.Printf(" Retries: %v git, %v API\n", stats.gitRetryCount, stats.apiRetryCount)
+	}
+	fmt.Println()
 }
 
 // print pull errors unstaged
@@ -110,9 +126,56 @@ func hasErrors(stats *GitStats) bool {
 		len(stats.generalErrors) > 0
 }
 
+// print per host breakdown
+func printHostSummaries(runs []*HostRun) {
+	fmt.Println("")
+	fmt.Println("Hosts:")
+	for _, run := range runs {
+		if run.Err != nil {
+			fmt.Printf("✗ %s failed: %v\n", run.Name, run.Err)
+			continue
+		}
+		fmt.Printf(
+			"• %s: cloned %v, pulled %v, errors %v\n",
+			run.Name,
+			run.Stats.clonedCount,
+			run.Stats.pulledCount,
+			run.Stats.errorCount,
+		)
+	}
+}
+
+// print summary of all hosts
+func printRunSummary(runs []*HostRun) {
+	if len(runs) == 1 {
+		printDetailedSummary(runs[0].Stats)
+		return
+	}
+
+	printHostSummaries(runs)
+
+	combined := &GitStats{}
+	for _, run := range runs {
+		combined.merge(run.Name, run.Stats)
+	}
+	printDetailedSummary(combined)
+}
+
+// print repositories whose branch differs from its upstream after fetching
+func printDivergedRepos(stats *GitStats) {
+	if len(stats.divergedRepos) > 0 {
+		fmt.Println("Repositories not in sync with their upstream:")
+		for _, repo := range stats.divergedRepos {
+			fmt.Printf("• %s\n", repo)
+		}
+		fmt.Println()
+	}
+}
+
 // print detailed summary
 func printDetailedSummary(stats *GitStats) {
 	printSummary(stats)
+	printDivergedRepos(stats)
 
 	if hasErrors(stats) {
 		fmt.Println("Error Details:")
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/scornet256/go-logger"
+)
+
+// Provider is implemented by every supported git backend
+type Provider interface {
+	// list all repositories the token has access to
+	FetchRepositories(ctx context.Context) ([]Repository, error)
+
+	// check that the host is reachable and the token is accepted
+	ValidateConnection(ctx context.Context) error
+
+	// url used to clone and pull a repository, without credentials
+	CloneURL(repo Repository) string
+
+	// username and password used for git operations
+	Credentials() (username, password string)
+}
+
+// providerFactory creates a provider from configuration
+type providerFactory func(conf *Config) Provider
+
+// registered providers keyed by git_backend
+var providers = map[string]providerFactory{}
+
+// register a provider for a git backend
+func registerProvider(backend string, factory providerFactory) {
+	providers[backend] = factory
+}
+
+// list supported backends
+func supportedBackends() string {
+	backends := make([]string, 0, len(providers))
+	for backend := range providers {
+		backends = append(backends, backend)
+	}
+	sort.Strings(backends)
+	return strings.Join(backends, "|")
+}
+
+// create provider for configured backend
+func newProvider(conf *Config) (Provider, error) {
+	factory, ok := providers[conf.GitBackend]
+	if !ok {
+		return nil, fmt.Errorf("unsupported git backend: %s (supported: %s)", conf.GitBackend, supportedBackends())
+	}
+	return factory(conf), nil
+}
+
+// fetch repositories from provider
+func FetchRepositories(ctx context.Context, provider Provider, filter *RepositoryFilter) ([]Repository, error) {
+	if err := provider.ValidateConnection(ctx); err != nil {
+		return nil, fmt.Errorf("validating connection: %w", err)
+	}
+
+	repositories, err := provider.FetchRepositories(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetching repositories: %w", err)
+	}
+
+	if len(repositories) == 0 {
+		return repositories, fmt.Errorf("no repositories found")
+	}
+
+	// apply include and exclude patterns
+	found := len(repositories)
+	repositories = filter.Apply(repositories)
+	if skipped := found - len(repositories); skipped > 0 {
+		logger.Print(fmt.Sprintf("Skipped %d repositories by include/exclude patterns", skipped), nil)
+	}
+
+	// update progress bar
+	if err := updateProgressBar(len(repositories)); err != nil {
+		logger.Print("WARNING: failed to update progress bar: "+err.Error(), nil)
+	}
+
+	logger.Print(fmt.Sprintf("Successfully fetched %d repositories", len(repositories)), nil)
+	return repositories, nil
+}
+
+// craft https clone url, credentials are supplied at fetch time
+func httpsCloneURL(host, repoName string) string {
+	return fmt.Sprintf("https://%s/%s.git", host, repoName)
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	"github.com/scornet256/go-logger"
+)
+
+// orphaned repositories are moved here
+const orphanedDir = "_orphaned"
+
+// repository in the destination that is no longer on any configured host
+type OrphanedRepository struct {
+	Path   string // absolute path
+	Unsafe string
+	Action string
+}
+
+// find and handle repositories that are no longer on the server
+func pruneDestinations(ctx context.Context, configs []*Config, action string) ([]OrphanedRepository, error) {
+	var orphans []OrphanedRepository
+
+	// hosts sharing a destination are pruned together
+	destinations := map[string][]*Config{}
+	var order []string
+	for _, conf := range configs {
+		if _, ok := destinations[conf.Destination]; !ok {
+			order = append(order, conf.Destination)
+		}
+		destinations[conf.Destination] = append(destinations[conf.Destination], conf)
+	}
+
+	for _, destination := range order {
+		destinationOrphans, err := pruneDestination(ctx, destination, destinations[destination], action)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", destination, err)
+		}
+		orphans = append(orphans, destinationOrphans...)
+	}
+
+	return orphans, nil
+}
+
+// find and handle orphaned repositories in a single destination
+func pruneDestination(ctx context.Context, destination string, configs []*Config, action string) ([]OrphanedRepository, error) {
+	known := map[string]bool{}
+	projectIDs := map[string]map[int]bool{}
+	gitHosts := map[string]bool{}
+	for _, conf := range configs {
+		globalConfig = conf
+
+		// sync filters only limit syncing, archived and filtered out repositories still exist
+		listConf := *conf
+		listConf.IncludeArchived = "any"
+		listConf.GiteaOwners = nil
+		listConf.GiteaTopics = nil
+
+		provider, err := newProvider(&listConf)
+		if err != nil {
+			return nil, err
+		}
+
+		repositories, err := FetchRepositories(ctx, provider, &RepositoryFilter{})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", conf.Name, err)
+		}
+		ids := map[int]bool{}
+		for _, repo := range repositories {
+			known[repo.PathWithNamespace] = true
+			if repo.ID != 0 {
+				ids[repo.ID] = true
+			}
+		}
+		projectIDs[conf.Name] = ids
+		gitHosts[hostName(conf.GitHost)] = true
+	}
+
+	repoPaths, err := findGitRepositories(destination)
+	if err != nil {
+		return nil, fmt.Errorf("scanning destination: %w", err)
+	}
+
+	state, err := loadState(destination)
+	if err != nil {
+		return nil, err
+	}
+
+	// repositories synced by the configured hosts, renamed ones are moved by the next sync instead
+	synced := map[string]bool{}
+	renamed := map[string]bool{}
+	for name, ids := range projectIDs {
+		hostState, ok := state.Hosts[name]
+		if !ok {
+			continue
+		}
+		for path, repoState := range hostState.Repositories {
+			if repoState.ProjectID != 0 && ids[repoState.ProjectID] {
+				renamed[path] = true
+			} else {
+				synced[path] = true
+			}
+		}
+	}
+
+	var orphans []OrphanedRepository
+	for _, repoPath := range repoPaths {
+		relPath, err := filepath.Rel(destination, repoPath)
+		if err != nil {
+			return nil, fmt.Errorf("resolving repository path: %w", err)
+		}
+		relPath = filepath.ToSlash(relPath)
+
+		// mirrors of a repository live in <path>.git
+		repoName := relPath
+		if isBareRepository(repoPath) {
+			repoName = strings.TrimSuffix(relPath, ".git")
+		}
+
+		if known[repoName] || renamed[repoName] || relPath == orphanedDir || strings.HasPrefix(relPath, orphanedDir+"/") {
+			continue
+		}
+
+		// leave repositories alone that were not cloned from a configured host
+		if !synced[repoName] && !originOnHost(repoPath, gitHosts) {
+			continue
+		}
+
+		orphan := OrphanedRepository{Path: repoPath, Action: "reported"}
+		orphan.Unsafe, err = unpushedWork(repoPath)
+		if err != nil {
+			orphan.Unsafe = err.Error()
+		}
+
+		switch {
+		case action == "move":
+			if err := moveOrphan(destination, relPath); err != nil {
+				return nil, err
+			}
+			orphan.Action = "moved"
+		case action == "delete" && orphan.Unsafe == "":
+			if err := os.RemoveAll(repoPath); err != nil {
+				return nil, fmt.Errorf("deleting %s: %w", relPath, err)
+			}
+			removeEmptyParents(repoPath)
+			orphan.Action = "deleted"
+		case action == "delete":
+			orphan.Action = "kept"
+		}
+		logger.Print("Orphaned repository "+relPath+": "+orphan.Action, nil)
+
+		// forget repositories that left the destination
+		if orphan.Action == "moved" || orphan.Action == "deleted" {
+			for _, hostState := range state.Hosts {
+				delete(hostState.Repositories, repoName)
+			}
+		}
+
+		orphans = append(orphans, orphan)
+	}
+
+	if action != "report" && len(orphans) > 0 {
+		if err := state.save(destination); err != nil {
+			return nil, err
+		}
+	}
+
+	return orphans, nil
+}
+
+// move an orphaned repository below the orphaned directory
+func moveOrphan(destination, relPath string) error {
+	target := filepath.Join(destination, orphanedDir, relPath)
+	if _, err := os.Stat(target); err == nil {
+		return fmt.Errorf("moving %s: %s already exists", relPath, target)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("moving %s: %w", relPath, err)
+	}
+
+	source := filepath.Join(destination, relPath)
+	if err := os.Rename(source, target); err != nil {
+		return fmt.Errorf("moving %s: %w", relPath, err)
+	}
+	removeEmptyParents(source)
+
+	return nil
+}
+
+// check if the origin remote of a repository points at one of the hosts
+func originOnHost(repoPath string, hosts map[string]bool) bool {
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return false
+	}
+	cfg, err := repo.Config()
+	if err != nil {
+		return false
+	}
+
+	origin, ok := cfg.Remotes[git.DefaultRemoteName]
+	if !ok {
+		return false
+	}
+	for _, remoteURL := range origin.URLs {
+		if hosts[remoteHost(remoteURL)] {
+			return true
+		}
+	}
+	return false
+}
+
+// host name of a remote url, including scp-like ssh urls such as git@host:group/repo.git
+func remoteHost(remoteURL string) string {
+	if u, err := url.Parse(remoteURL); err == nil && u.Host != "" {
+		return u.Hostname()
+	}
+
+	host := remoteURL
+	if at := strings.Index(host, "@"); at >= 0 {
+		host = host[at+1:]
+	}
+	if colon := strings.Index(host, ":"); colon >= 0 {
+		host = host[:colon]
+	}
+	return host
+}
+
+// host name of a git_host setting, without port
+func hostName(gitHost string) string {
+	if u, err := url.Parse("//" + gitHost); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+	return gitHost
+}
+
+// describe work that only exists locally, empty if everything was pushed
+func unpushedWork(repoPath string) (string, error) {
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return "", fmt.Errorf("opening repository: %w", err)
+	}
+
+	// a mirror may be the last copy of a repository deleted on the server
+	worktree, err := repo.Worktree()
+	if err == git.ErrIsBareRepository {
+		return "mirror", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("getting worktree: %w", err)
+	}
+	status, err := worktree.Status()
+	if err != nil {
+		return "", fmt.Errorf("checking status: %w", err)
+	}
+	if changes := localChanges(status); changes != "" {
+		return changes + " changes", nil
+	}
+
+	if _, err := repo.Reference(plumbing.ReferenceName("refs/stash"), false); err == nil {
+		return "stashed changes", nil
+	}
+
+	// every local branch has to be contained in a remote branch
+	var remoteCommits []*object.Commit
+	var localHashes []plumbing.Hash
+	refs, err := repo.References()
+	if err != nil {
+		return "", fmt.Errorf("listing references: %w", err)
+	}
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		switch {
+		case ref.Name().IsRemote():
+			commit, err := repo.CommitObject(ref.Hash())
+			if err == nil {
+				remoteCommits = append(remoteCommits, commit)
+			}
+		case ref.Name().IsBranch():
+			localHashes = append(localHashes, ref.Hash())
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("listing references: %w", err)
+	}
+
+	for _, hash := range localHashes {
+		pushed, err := isPushed(repo, hash, remoteCommits)
+		if err != nil {
+			return "", err
+		}
+		if !pushed {
+			return "unpushed commits", nil
+		}
+	}
+
+	return "", nil
+}
+
+// check if a commit is reachable from any remote branch
+func isPushed(repo *git.Repository, hash plumbing.Hash, remoteCommits []*object.Commit) (bool, error) {
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return false, fmt.Errorf("reading commit %s: %w", hash, err)
+	}
+
+	for _, remoteCommit := range remoteCommits {
+		if remoteCommit.Hash == hash {
+			return true, nil
+		}
+		contained, err := commit.IsAncestor(remoteCommit)
+		if err != nil {
+			return false, fmt.Errorf("comparing commits: %w", err)
+		}
+		if contained {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// print orphaned repositories and what happened to them
+func printPruneSummary(orphans []OrphanedRepository) {
+	sort.Slice(orphans, func(i, j int) bool {
+		return orphans[i].Path < orphans[j].Path
+	})
+
+	fmt.Println("")
+	fmt.Printf("Orphaned repositories: %v\n", len(orphans))
+	for _, orphan := range orphans {
+		if orphan.Unsafe != "" {
+			fmt.Printf("• %s: %s (%s)\n", orphan.Path, orphan.Action, orphan.Unsafe)
+		} else {
+			fmt.Printf("• %s: %s\n", orphan.Path, orphan.Action)
+		}
+	}
+	fmt.Println()
+}
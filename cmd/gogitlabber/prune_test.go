@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v6"
+)
+
+// provider that only lists the archived repository without sync filters
+type pruneTestProvider struct {
+	conf *Config
+}
+
+func (p *pruneTestProvider) FetchRepositories(ctx context.Context) ([]Repository, error) {
+	repositories := []Repository{{ID: 1, PathWithNamespace: "grp/active"}}
+	if p.conf.IncludeArchived == "any" && len(p.conf.GiteaOwners) == 0 && len(p.conf.GiteaTopics) == 0 {
+		repositories = append(repositories, Repository{ID: 2, PathWithNamespace: "grp/archived"})
+	}
+	return repositories, nil
+}
+
+func (p *pruneTestProvider) ValidateConnection(ctx context.Context) error { return nil }
+
+func (p *pruneTestProvider) CloneURL(repo Repository) string {
+	return httpsCloneURL(p.conf.GitHost, repo.PathWithNamespace)
+}
+
+func (p *pruneTestProvider) Credentials() (string, string) { return "", "" }
+
+func init() {
+	registerProvider("prune-test", func(conf *Config) Provider {
+		return &pruneTestProvider{conf: conf}
+	})
+}
+
+func TestPruneKeepsArchivedAndFilteredRepositories(t *testing.T) {
+	destination := t.TempDir()
+	repoPath := filepath.Join(destination, "grp", "archived")
+	if _, err := git.PlainInit(repoPath, false); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &Config{
+		Name:            "test",
+		GitBackend:      "prune-test",
+		GitHost:         "git.example.com",
+		Destination:     destination,
+		IncludeArchived: "excluded",
+		GiteaOwners:     []string{"someone"},
+		GiteaTopics:     []string{"backup"},
+	}
+
+	// the repository was synced before it was archived
+	state := &SyncState{Hosts: map[string]*HostState{}}
+	state.host(conf.Name).Repositories["grp/archived"] = &RepoState{ProjectID: 2}
+	if err := state.save(destination); err != nil {
+		t.Fatal(err)
+	}
+
+	orphans, err := pruneDestinations(context.Background(), []*Config{conf}, "delete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 0 {
+		t.Errorf("expected no orphans, got %+v", orphans)
+	}
+	if _, err := os.Stat(repoPath); err != nil {
+		t.Errorf("archived repository was removed: %v", err)
+	}
+}
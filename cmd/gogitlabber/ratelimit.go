@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/scornet256/go-logger"
+)
+
+// rate limited responses we wait out before counting them as failures
+const maxRateLimitPauses = 10
+
+// rateLimiter spaces out api requests and pauses when the server asks for it
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// rate limiter allowing the given requests per second, 0 for unlimited
+func newRateLimiter(requestsPerSecond float64) *rateLimiter {
+	limiter := &rateLimiter{}
+	if requestsPerSecond > 0 {
+		limiter.interval = time.Duration(float64(time.Second) / requestsPerSecond)
+	}
+	return limiter
+}
+
+// wait for the next request slot
+func (l *rateLimiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	start := time.Now()
+	if l.next.After(start) {
+		start = l.next
+	}
+	l.next = start.Add(l.interval)
+	l.mu.Unlock()
+
+	delay := time.Until(start)
+	if delay <= 0 {
+		return nil
+	}
+	return sleepContext(ctx, delay)
+}
+
+// hold back all requests until the given time
+func (l *rateLimiter) pauseUntil(until time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if until.After(l.next) {
+		l.next = until
+	}
+}
+
+// read rate limit headers, reports whether the request should be repeated after the pause
+func (l *rateLimiter) observe(resp *http.Response) bool {
+	now := time.Now()
+
+	// server tells us exactly how long to back off
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		if delay, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now); ok {
+			logger.Print(fmt.Sprintf("Rate limited, pausing API requests for %s", delay), nil)
+			l.pauseUntil(now.Add(delay))
+			return true
+		}
+	}
+
+	// quota used up, wait for it to reset
+	remaining := rateLimitHeader(resp.Header, "Remaining")
+	if remaining == "0" {
+		if reset, ok := parseRateLimitReset(rateLimitHeader(resp.Header, "Reset"), now); ok {
+			logger.Print(fmt.Sprintf("Rate limit exhausted, pausing API requests until %s", reset.Format(time.TimeOnly)), nil)
+			l.pauseUntil(reset)
+			return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusForbidden
+		}
+	}
+
+	return false
+}
+
+// gitlab sends RateLimit-*, github and proxies X-RateLimit-*
+func rateLimitHeader(header http.Header, name string) string {
+	if value := header.Get("RateLimit-" + name); value != "" {
+		return value
+	}
+	return header.Get("X-RateLimit-" + name)
+}
+
+// retry-after is either seconds or an http date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// reset is a unix timestamp on gitlab and github, seconds from now elsewhere
+func parseRateLimitReset(value string, now time.Time) (time.Time, bool) {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		return time.Time{}, false
+	}
+	if seconds > 1_000_000_000 {
+		return time.Unix(seconds, 0), true
+	}
+	return now.Add(time.Duration(seconds) * time.Second), true
+}
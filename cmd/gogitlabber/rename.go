@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/scornet256/go-logger"
+)
+
+// repository that moved to a new path on the server
+type repoMove struct {
+	From string
+	To   string
+}
+
+// previous path of a renamed or transferred repository, empty if it did not move
+func renamedFrom(repo Repository, hostState *HostState) string {
+	if repo.ID == 0 || hostState == nil {
+		return ""
+	}
+
+	for path, repoState := range hostState.Repositories {
+		if repoState.ProjectID != repo.ID || path == repo.PathWithNamespace {
+			continue
+		}
+
+		// only move checkouts that are still there into free paths
+		if !isGitRepository(localPath(path)) {
+			continue
+		}
+		if _, err := os.Stat(localPath(repo.PathWithNamespace)); !os.IsNotExist(err) {
+			continue
+		}
+		return path
+	}
+
+	return ""
+}
+
+// move checkouts of renamed repositories to their new path, keeping local branches and stashes
+func moveRenamedRepositories(repositories []Repository, hostState *HostState) []repoMove {
+	var moves []repoMove
+
+	for _, repo := range repositories {
+		oldPath := renamedFrom(repo, hostState)
+		if oldPath == "" {
+			continue
+		}
+
+		if err := moveRepository(oldPath, repo.PathWithNamespace); err != nil {
+			logger.Print("WARNING: failed to move "+oldPath+" to "+repo.PathWithNamespace+": "+err.Error(), nil)
+			continue
+		}
+		logger.Print("Moved renamed repository "+oldPath+" to "+repo.PathWithNamespace, nil)
+
+		// the state follows the checkout, pulling right away points the remote to the new path
+		repoState := hostState.Repositories[oldPath]
+		repoState.LastSync = time.Time{}
+		hostState.Repositories[repo.PathWithNamespace] = repoState
+		delete(hostState.Repositories, oldPath)
+
+		moves = append(moves, repoMove{From: oldPath, To: repo.PathWithNamespace})
+	}
+
+	return moves
+}
+
+// move a checkout inside the destination
+func moveRepository(oldPath, newPath string) error {
+	oldDestination := localPath(oldPath)
+	newDestination := localPath(newPath)
+
+	if err := os.MkdirAll(filepath.Dir(newDestination), 0755); err != nil {
+		return fmt.Errorf("creating parent directory: %w", err)
+	}
+	if err := os.Rename(oldDestination, newDestination); err != nil {
+		return fmt.Errorf("renaming directory: %w", err)
+	}
+
+	removeEmptyParents(oldDestination)
+	return nil
+}
+
+// check if a directory holds a git checkout or a bare mirror
+func isGitRepository(path string) bool {
+	if _, err := os.Stat(filepath.Join(path, ".git")); err == nil {
+		return true
+	}
+	return isBareRepository(path)
+}
+
+// check if a directory is a bare repository
+func isBareRepository(path string) bool {
+	for _, name := range []string{"HEAD", "objects", "refs"} {
+		if _, err := os.Stat(filepath.Join(path, name)); err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// machine readable result of a run
+type Report struct {
+	Version    string       `json:"version"`
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt time.Time    `json:"finished_at"`
+	Hosts      []HostReport `json:"hosts"`
+}
+
+// machine readable result of a single host
+type HostReport struct {
+	Name         string       `json:"name"`
+	Error        string       `json:"error,omitempty"`
+	Cloned       int          `json:"cloned"`
+	Pulled       int          `json:"pulled"`
+	Fetched      int          `json:"fetched"`
+	Unchanged    int          `json:"unchanged"`
+	Errors       int          `json:"errors"`
+	APIRetries   int          `json:"api_retries"`
+	Repositories []RepoReport `json:"repositories"`
+}
+
+// machine readable result of a single repository
+type RepoReport struct {
+	Host       string `json:"host"`
+	Path       string `json:"path"`
+	Operation  string `json:"operation"`
+	ErrorType  string `json:"error_type,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+	OldHead    string `json:"old_head,omitempty"`
+	NewHead    string `json:"new_head,omitempty"`
+	Retries    int    `json:"retries,omitempty"`
+	Behind     int    `json:"behind,omitempty"`
+	Ahead      int    `json:"ahead,omitempty"`
+}
+
+// build report from host runs
+func buildReport(runs []*HostRun, startedAt time.Time) Report {
+	report := Report{
+		Version:    version,
+		StartedAt:  startedAt,
+		FinishedAt: time.Now(),
+	}
+
+	for _, run := range runs {
+		hostReport := HostReport{
+			Name:         run.Name,
+			Cloned:       run.Stats.clonedCount,
+			Pulled:       run.Stats.pulledCount,
+			Fetched:      run.Stats.fetchedCount,
+			Unchanged:    run.Stats.unchangedCount,
+			Errors:       run.Stats.errorCount,
+			APIRetries:   run.Stats.apiRetryCount,
+			Repositories: []RepoReport{},
+		}
+		if run.Err != nil {
+			hostReport.Error = redactToken(run.Err.Error(), run.token)
+		}
+
+		for _, result := range run.Stats.results {
+			hostReport.Repositories = append(hostReport.Repositories, newRepoReport(run, result))
+		}
+
+		report.Hosts = append(report.Hosts, hostReport)
+	}
+
+	return report
+}
+
+// convert git operation result to report entry
+func newRepoReport(run *HostRun, result GitOperationResult) RepoReport {
+	entry := RepoReport{
+		Host:       run.Name,
+		Path:       result.RepoName,
+		Operation:  result.Operation,
+		DurationMS: result.Duration.Milliseconds(),
+		OldHead:    result.OldHead,
+		NewHead:    result.NewHead,
+		Retries:    result.Retries,
+		Behind:     result.Behind,
+		Ahead:      result.Ahead,
+	}
+
+	if result.Operation == "error" {
+		entry.ErrorType = result.ErrorType
+		if entry.ErrorType == "" {
+			entry.ErrorType = "other"
+		}
+	}
+	if result.Error != nil {
+		entry.Error = redactToken(result.Error.Error(), run.token)
+	}
+
+	return entry
+}
+
+// write report as json or ndjson to a file or stdout
+func writeReport(report Report, path, format string) error {
+	if path == "-" {
+		return encodeReport(os.Stdout, report, format)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("creating report file: %w", err)
+	}
+
+	if err := encodeReport(file, report, format); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("closing report file: %w", err)
+	}
+
+	return nil
+}
+
+// encode report in the requested format
+func encodeReport(out io.Writer, report Report, format string) error {
+	encoder := json.NewEncoder(out)
+
+	// one line per repository, failed hosts get a line of their own
+	if format == "ndjson" {
+		for _, hostReport := range report.Hosts {
+			if hostReport.Error != "" {
+				entry := RepoReport{Host: hostReport.Name, Operation: "error", ErrorType: "host", Error: hostReport.Error}
+				if err := encoder.Encode(entry); err != nil {
+					return fmt.Errorf("writing report: %w", err)
+				}
+			}
+			for _, entry := range hostReport.Repositories {
+				if err := encoder.Encode(entry); err != nil {
+					return fmt.Errorf("writing report: %w", err)
+				}
+			}
+		}
+		return nil
+	}
+
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return fmt.Errorf("writing report: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	githttp "github.com/go-git/go-git/v6/plumbing/transport/http"
+	"github.com/scornet256/go-logger"
+)
+
+// RetryPolicy describes how often and how fast failed network operations are retried
+type RetryPolicy struct {
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Jitter     float64
+}
+
+// retry policy from config
+func newRetryPolicy(conf *Config) RetryPolicy {
+	return RetryPolicy{
+		Attempts:   conf.RetryAttempts,
+		Backoff:    conf.RetryBackoff,
+		MaxBackoff: conf.RetryMaxBackoff,
+		Jitter:     conf.RetryJitter,
+	}
+}
+
+// error carrying the status of a failed api request
+type httpStatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("API request failed with status %d: %s", e.StatusCode, e.Status)
+}
+
+// delay before the given retry, doubling each time
+func (p RetryPolicy) delay(retry int) time.Duration {
+	delay := p.Backoff
+	for i := 1; i < retry && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+
+	// spread retries of concurrent workers
+	if p.Jitter > 0 {
+		delay += time.Duration(rand.Float64() * p.Jitter * float64(delay))
+	}
+
+	return delay
+}
+
+// run operation until it succeeds, fails permanently or attempts run out
+func (p RetryPolicy) do(ctx context.Context, name string, operation func() error) (int, error) {
+	retries := 0
+	for {
+		err := operation()
+		if err == nil || retries+1 >= p.Attempts || !isRetryable(err) || ctx.Err() != nil {
+			return retries, err
+		}
+
+		retries++
+		delay := p.delay(retries)
+		logger.Print(fmt.Sprintf("Retrying %s in %s (%d/%d): %s", name, delay, retries, p.Attempts-1, err.Error()), nil)
+
+		if err := sleepContext(ctx, delay); err != nil {
+			return retries, err
+		}
+	}
+}
+
+// wait unless cancelled
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// check if an error is worth another attempt
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	// server side trouble
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return retryableStatus(statusErr.StatusCode)
+	}
+	var gitErr *githttp.Err
+	if errors.As(err, &gitErr) {
+		return retryableStatus(gitErr.StatusCode())
+	}
+
+	// connection trouble
+	if errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	// transports that only hand out error strings
+	message := strings.ToLower(err.Error())
+	for _, fragment := range []string{"connection reset", "unexpected eof", "tls handshake timeout", "i/o timeout", "broken pipe"} {
+		if strings.Contains(message, fragment) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// statuses that are likely to go away on their own
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
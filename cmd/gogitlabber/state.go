@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// state file kept in the destination directory
+const stateFileName = ".gogitlabber-state.json"
+
+// number of runs kept for the history command
+const maxStoredRuns = 50
+
+// SyncState is what gogitlabber remembers between runs
+type SyncState struct {
+	Hosts map[string]*HostState `json:"hosts"`
+	Runs  []RunRecord           `json:"runs"`
+}
+
+// remembered state of a single host
+type HostState struct {
+	Repositories map[string]*RepoState `json:"repositories"`
+}
+
+// remembered state of a single repository
+type RepoState struct {
+	ProjectID int       `json:"project_id,omitempty"`
+	LastSync  time.Time `json:"last_sync,omitzero"`
+	LastHead  string    `json:"last_head,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// summary of a finished run of a host
+type RunRecord struct {
+	Host       string      `json:"host"`
+	StartedAt  time.Time   `json:"started_at"`
+	FinishedAt time.Time   `json:"finished_at"`
+	Cloned     int         `json:"cloned"`
+	Pulled     int         `json:"pulled"`
+	Fetched    int         `json:"fetched,omitempty"`
+	Unchanged  int         `json:"unchanged"`
+	Errors     int         `json:"errors"`
+	Changes    []RunChange `json:"changes,omitempty"`
+}
+
+// repository that was cloned, moved, updated or failed during a run
+type RunChange struct {
+	Path      string `json:"path"`
+	Operation string `json:"operation"`
+	From      string `json:"from,omitempty"`
+	OldHead   string `json:"old_head,omitempty"`
+	NewHead   string `json:"new_head,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// path of the state file for a destination
+func statePath(destination string) string {
+	return filepath.Join(destination, stateFileName)
+}
+
+// load state, a missing file is an empty state
+func loadState(destination string) (*SyncState, error) {
+	state := &SyncState{Hosts: map[string]*HostState{}}
+
+	data, err := os.ReadFile(statePath(destination))
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading state file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("parsing state file: %w", err)
+	}
+	if state.Hosts == nil {
+		state.Hosts = map[string]*HostState{}
+	}
+
+	return state, nil
+}
+
+// write state atomically so an interrupted run can't corrupt it
+func (state *SyncState) save(destination string) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding state: %w", err)
+	}
+
+	if err := os.MkdirAll(destination, 0755); err != nil {
+		return fmt.Errorf("creating destination: %w", err)
+	}
+
+	tmpPath := statePath(destination) + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("writing state file: %w", err)
+	}
+	if err := os.Rename(tmpPath, statePath(destination)); err != nil {
+		return fmt.Errorf("replacing state file: %w", err)
+	}
+
+	return nil
+}
+
+// state of a host, created on first use
+func (state *SyncState) host(name string) *HostState {
+	hostState, ok := state.Hosts[name]
+	if !ok {
+		hostState = &HostState{}
+		state.Hosts[name] = hostState
+	}
+	if hostState.Repositories == nil {
+		hostState.Repositories = map[string]*RepoState{}
+	}
+	return hostState
+}
+
+// state of a repository, nil if it was never synced
+func (hostState *HostState) repo(path string) *RepoState {
+	return hostState.Repositories[path]
+}
+
+// record the results of a run, syncStarted is used as sync time
+// so activity during the run is picked up next time
+func (state *SyncState) record(run *HostRun, repositories []Repository, syncStarted time.Time) {
+	hostState := state.host(run.Name)
+
+	projectIDs := map[string]int{}
+	for _, repo := range repositories {
+		projectIDs[repo.PathWithNamespace] = repo.ID
+	}
+
+	record := RunRecord{
+		Host:       run.Name,
+		StartedAt:  syncStarted,
+		FinishedAt: time.Now(),
+		Cloned:     run.Stats.clonedCount,
+		Pulled:     run.Stats.pulledCount,
+		Fetched:    run.Stats.fetchedCount,
+		Unchanged:  run.Stats.unchangedCount,
+		Errors:     run.Stats.errorCount,
+	}
+
+	for _, move := range run.Stats.moves {
+		record.Changes = append(record.Changes, RunChange{Path: move.To, Operation: "moved", From: move.From})
+	}
+
+	for _, result := range run.Stats.results {
+		repoState := hostState.repo(result.RepoName)
+		if repoState == nil {
+			repoState = &RepoState{}
+			hostState.Repositories[result.RepoName] = repoState
+		}
+		if id := projectIDs[result.RepoName]; id != 0 {
+			repoState.ProjectID = id
+		}
+
+		change := RunChange{Path: result.RepoName, Operation: result.Operation, OldHead: result.OldHead, NewHead: result.NewHead}
+		switch result.Operation {
+		case "cloned", "pulled", "fetched":
+			repoState.LastSync = syncStarted
+			repoState.LastHead = result.NewHead
+			repoState.LastError = ""
+			if result.Operation == "cloned" || result.OldHead != result.NewHead {
+				record.Changes = append(record.Changes, change)
+			}
+		case "error":
+			repoState.LastError = redactToken(result.Error.Error(), run.token)
+			change.Error = repoState.LastError
+			record.Changes = append(record.Changes, change)
+		}
+	}
+
+	state.Runs = append(state.Runs, record)
+	if len(state.Runs) > maxStoredRuns {
+		state.Runs = state.Runs[len(state.Runs)-maxStoredRuns:]
+	}
+}
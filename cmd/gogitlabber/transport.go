@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v6/plumbing/client"
+	githttp "github.com/go-git/go-git/v6/plumbing/transport/http"
+	"github.com/go-git/go-git/v6/plumbing/transport/ssh"
+	"github.com/scornet256/go-logger"
+)
+
+// gitRemote holds how repositories of a provider are reached
+type gitRemote struct {
+	provider      Provider
+	protocol      string
+	sshPort       int
+	sshKeyFile    string
+	sshKnownHosts string
+	clientOptions []client.Option
+	retry         RetryPolicy
+	cloneDefaults CloneSettings
+	cloneRules    []cloneRule
+	mirror        bool
+	fetchOnly     bool
+}
+
+// set up remote access for configured protocol
+func newGitRemote(provider Provider, conf *Config) (*gitRemote, error) {
+	remote := &gitRemote{
+		provider:      provider,
+		protocol:      conf.CloneProtocol,
+		sshPort:       conf.SSHPort,
+		sshKeyFile:    conf.SSHKeyFile,
+		sshKnownHosts: conf.SSHKnownHosts,
+		retry:         newRetryPolicy(conf),
+		mirror:        conf.Mode == "mirror",
+		fetchOnly:     conf.UpdateStrategy == "fetch",
+	}
+
+	rules, err := newCloneRules(conf.CloneOverrides)
+	if err != nil {
+		return nil, err
+	}
+	remote.cloneDefaults = conf.cloneSettings()
+	remote.cloneRules = rules
+
+	if conf.CloneProtocol == "ssh" {
+		auth, err := newSSHAuth(conf)
+		if err != nil {
+			return nil, fmt.Errorf("setting up ssh authentication: %w", err)
+		}
+		remote.clientOptions = append(remote.clientOptions, client.WithSSHAuth(auth))
+	} else {
+		remote.clientOptions = append(remote.clientOptions, client.WithHTTPAuth(&providerAuth{provider: provider}))
+	}
+
+	return remote, nil
+}
+
+// clone settings for a repository
+func (r *gitRemote) cloneSettings(repoName string) CloneSettings {
+	return resolveCloneSettings(r.cloneDefaults, r.cloneRules, repoName)
+}
+
+// providerAuth supplies provider credentials at fetch time
+// so they never end up in a remote url on disk
+type providerAuth struct {
+	provider Provider
+}
+
+// set basic auth on git http requests
+func (a *providerAuth) Authorizer(req *http.Request) error {
+	username, password := a.provider.Credentials()
+	auth := &githttp.BasicAuth{Username: username, Password: password}
+	return auth.Authorizer(req)
+}
+
+// url used to clone and pull a repository
+func (r *gitRemote) url(repo Repository) (string, error) {
+	if r.protocol != "ssh" {
+		return r.provider.CloneURL(repo), nil
+	}
+
+	if repo.SSHURL == "" {
+		return "", fmt.Errorf("no ssh url available for %s", repo.PathWithNamespace)
+	}
+
+	return sshURLWithPort(repo.SSHURL, r.sshPort)
+}
+
+// ssh authentication from key file or ssh-agent
+func newSSHAuth(conf *Config) (client.SSHAuth, error) {
+	var helper ssh.HostKeyCallbackHelper
+	if conf.SSHKnownHosts != "" {
+		callback, err := ssh.NewKnownHostsCallback(conf.SSHKnownHosts)
+		if err != nil {
+			return nil, fmt.Errorf("loading known hosts: %w", err)
+		}
+		helper.HostKeyCallback = callback
+	}
+
+	if conf.SSHKeyFile != "" {
+		auth, err := ssh.NewPublicKeysFromFile(ssh.DefaultUsername, conf.SSHKeyFile, conf.SSHKeyPassword)
+		if err != nil {
+			return nil, fmt.Errorf("loading key file: %w", err)
+		}
+		auth.HostKeyCallbackHelper = helper
+		return auth, nil
+	}
+
+	logger.Print("Using ssh-agent for authentication", nil)
+	auth, err := ssh.NewSSHAgentAuth(ssh.DefaultUsername)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to ssh-agent: %w", err)
+	}
+	auth.HostKeyCallbackHelper = helper
+	return auth, nil
+}
+
+// rewrite ssh url to use a custom port
+func sshURLWithPort(sshURL string, port int) (string, error) {
+	if port == 0 {
+		return sshURL, nil
+	}
+
+	// convert scp-like syntax (git@host:group/repo.git) to a url
+	if !strings.Contains(sshURL, "://") {
+		userHost, path, ok := strings.Cut(sshURL, ":")
+		if !ok {
+			return "", fmt.Errorf("invalid ssh url: %s", sshURL)
+		}
+		sshURL = "ssh://" + userHost + "/" + strings.TrimPrefix(path, "/")
+	}
+
+	u, err := url.Parse(sshURL)
+	if err != nil {
+		return "", fmt.Errorf("parsing ssh url: %w", err)
+	}
+
+	u.Host = u.Hostname() + ":" + strconv.Itoa(port)
+	return u.String(), nil
+}